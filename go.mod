@@ -1,6 +1,6 @@
 module github.com/Ecsy/dhtstore
 
-go 1.12
+go 1.13
 
 require (
 	github.com/anacrolix/missinggo v0.0.0-20180412013123-ef490447bf2e // indirect
@@ -0,0 +1,459 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Ecsy/dhtstore/src/network"
+	"github.com/mh-cbon/dht/dht"
+	"github.com/mh-cbon/dht/ed25519"
+	cryptoed25519 "golang.org/x/crypto/ed25519"
+)
+
+// commands are the subcommands of the program, in the order of the usage.
+var commands = []*command{
+	{
+		name:  "sign",
+		args:  "-value <value> | -file <path> [-encoding <encoding>] [-salt <salt>] [-seq <seq>]",
+		short: "sign a mutable value without publishing it",
+		long:  "Sign prints the target and the signature of a mutable value signed with the private key.",
+		setup: sign,
+	},
+	{
+		name:  "put",
+		args:  "-value <value> | -file <path> [-encoding <encoding>] [-salt <salt>] [-seq <seq>] [-immutable] [-republish <interval>]",
+		short: "store a value in the DHT network",
+		long: "Put stores a mutable value signed with the private key, or an immutable value with -immutable.\n" +
+			"The value is read from -value or -file, - for stdin, and decoded with -encoding.\n" +
+			"The seq of a mutable value is the one after the seq found in the journal or the network unless -seq is set.\n" +
+			"Values longer than 999 bytes are stored as chunks, except with -seq or -immutable where they are refused.",
+		setup: put,
+	},
+	{
+		name:  "get",
+		args:  "[-pubkey <hex>] [-salt <salt>] [-seq <seq>] [-target <hex>] [-immutable] [-encoding <encoding>]",
+		short: "retrieve a value from the DHT network",
+		long: "Get retrieves the mutable value of a public key and salt, the local public key when -pubkey is not set.\n" +
+			"With -immutable it retrieves the immutable value of -target.\n" +
+			"It returns the first valid value found, a value not found yet is asked again until the global -timeout,\n" +
+			"1m when not set.",
+		setup: get,
+	},
+	{
+		name:  "watch",
+		args:  "[-pubkey <hex>] [-salt <salt>] [-interval <duration>] [-encoding <encoding>]",
+		short: "print a mutable value every time it changes",
+		long: "Watch polls the mutable value of a public key and salt every -interval, it prints the first value found\n" +
+			"then the value only when its seq changes, until it is stopped or the global -timeout.",
+		setup: watch,
+	},
+	{
+		name:  "keys",
+		args:  "[-generate]",
+		short: "show or generate the private key",
+		long:  "Keys prints the public key of the private key of the -keys directory, -generate creates it.",
+		setup: keys,
+	},
+	{
+		name:  "serve",
+		args:  "",
+		short: "run a DHT node until it is stopped",
+		long:  "Serve bootstraps a DHT node and answers the queries of the network until it is stopped.",
+		setup: serve,
+	},
+	{
+		name:  "nodes",
+		args:  "",
+		short: "print the nodes of the routing table",
+		long:  "Nodes bootstraps a DHT node and prints the address of every node of its routing table.",
+		setup: nodes,
+	},
+}
+
+func sign(fs *flag.FlagSet) func(o *options) error {
+	valueFlags := newValueFlags(fs)
+	salt := fs.String("salt", "", "salt of the value")
+	seq := fs.Int("seq", 1, "sequence number of the value")
+	return func(o *options) error {
+		value, err := valueFlags.read(o.in)
+		if err != nil {
+			return err
+		}
+		privateKey, _, err := o.keys()
+		if err != nil {
+			return err
+		}
+		m, err := o.config().MutableTarget(privateKey, value, *seq, *salt)
+		if err != nil {
+			return err
+		}
+		o.result(signResult{Target: m.Target, PublicKey: m.Pbk, Salt: *salt, Seq: m.Seq, Signature: m.Sign})
+		return nil
+	}
+}
+
+func put(fs *flag.FlagSet) func(o *options) error {
+	valueFlags := newValueFlags(fs)
+	salt := fs.String("salt", "", "salt of the mutable value")
+	seq := fs.Int("seq", 0, "sequence number, the next one from the journal or the network when not set")
+	journal := fs.String("journal", "", "seq journal file, the current seq is read from it instead of the network")
+	repub := fs.Duration("republish", 0, "keep running after put and republish the value at this interval")
+	minRepl := fs.Int("min-replicas", 0, "fail a put stored by less nodes than this")
+	immutable := fs.Bool("immutable", false, "store an immutable value, addressed by its content")
+	return func(o *options) error {
+		autoSeq := true
+		fs.Visit(func(f *flag.Flag) {
+			if f.Name == "seq" {
+				autoSeq = false
+			}
+		})
+		if *immutable && (*salt != "" || !autoSeq || *journal != "" || *repub > 0) {
+			return usagef("-immutable values have no salt, seq, journal or republish")
+		}
+		value, err := valueFlags.read(o.in)
+		if err != nil {
+			return err
+		}
+		// checked before any query, chunks are only stored by publish.
+		if err := checkPut(value, o.config().Salt(*salt), autoSeq && !*immutable); err != nil {
+			return err
+		}
+		opts := []network.Opt{network.Opts.WithMinReplicas(*minRepl)}
+		if *journal != "" {
+			opts = append(opts, network.Opts.WithSeqJournal(network.NewFileJournal(*journal)))
+		}
+		if *immutable {
+			return putImmutable(o, value, opts)
+		}
+
+		privateKey, _, err := o.keys()
+		if err != nil {
+			return err
+		}
+		ctx, cancel := o.context()
+		defer cancel()
+		return o.withDHT(ctx, opts, func(n *network.DHT) error {
+			t := time.Now()
+			var m *dht.MutablePut
+			var r *network.PutResult
+			if autoSeq {
+				m, r, err = n.PublishResult(ctx, privateKey, *salt, value)
+			} else {
+				m, r, err = putSeq(ctx, n, o.config(), privateKey, value, *seq, *salt)
+			}
+			if r != nil {
+				logPutResult(r)
+			}
+			if err != nil {
+				return err
+			}
+			lg.Log(network.LevelInfo, "put done", "target", m.Target, "seq", m.Seq, "duration", time.Now().Sub(t))
+			o.result(newPutResult(r, putResult{PublicKey: m.Pbk, Salt: *salt, Seq: m.Seq, Size: len(value)}))
+			if *repub <= 0 {
+				return nil
+			}
+			rp := network.NewRepublisher(n, *repub, *repub/10)
+			rp.Add(m)
+			if err := rp.Run(ctx); err != nil && ctx.Err() == nil {
+				return err
+			}
+			return nil
+		})
+	}
+}
+
+// putSeq stores value with the given seq.
+func putSeq(ctx context.Context, n *network.DHT, config network.NetworkConfig, privateKey ed25519.PrivateKey, value string, seq int, salt string) (*dht.MutablePut, *network.PutResult, error) {
+	m, err := config.MutableTarget(privateKey, value, seq, salt)
+	if err != nil {
+		return nil, nil, err
+	}
+	r, err := n.Replicate(ctx, m)
+	return m, r, err
+}
+
+func putImmutable(o *options, value string, opts []network.Opt) error {
+	ctx, cancel := o.context()
+	defer cancel()
+	return o.withDHT(ctx, opts, func(n *network.DHT) error {
+		t := time.Now()
+		r, err := n.ReplicateImmutable(ctx, value)
+		if r != nil {
+			logPutResult(r)
+		}
+		if err != nil {
+			return err
+		}
+		lg.Log(network.LevelInfo, "put done", "target", r.Target, "duration", time.Now().Sub(t))
+		o.result(newPutResult(r, putResult{Immutable: true, Size: len(value)}))
+		return nil
+	})
+}
+
+// logPutResult logs the answer of every node to a put.
+func logPutResult(r *network.PutResult) {
+	lg.Log(network.LevelInfo, "put result", "target", r.Target,
+		"accepted", len(r.Accepted), "rejected", len(r.Rejected), "timed_out", len(r.TimedOut))
+	for _, n := range r.Rejected {
+		lg.Log(network.LevelWarn, "put rejected", "target", r.Target, "remote", n.Addr, "code", n.Err.Code, "error", n.Err.Msg)
+	}
+}
+
+func get(fs *flag.FlagSet) func(o *options) error {
+	pubkey := fs.String("pubkey", "", "hex public key of the value, the local public key when not set")
+	salt := fs.String("salt", "", "salt of the mutable value")
+	seq := fs.Int("seq", 0, "only get the mutable value if its sequence number is above this one")
+	target := fs.String("target", "", "hex target, computed from the public key and salt of mutable values when not set")
+	immutable := fs.Bool("immutable", false, "retrieve the immutable value of -target")
+	enc := outputEncodingFlag(fs, "encoding of the value written")
+	return func(o *options) error {
+		encoding, err := o.outputEncoding(*enc)
+		if err != nil {
+			return err
+		}
+		if *immutable {
+			if *pubkey != "" || *salt != "" {
+				return usagef("-immutable values have no public key or salt")
+			}
+			if len(*target) != 40 {
+				return usagef("-immutable requires a 40 hex characters -target")
+			}
+			return getImmutable(o, *target, encoding)
+		}
+		pbk, err := o.publicKey(*pubkey)
+		if err != nil {
+			return err
+		}
+		hash := o.config().Target(pbk, *salt)
+		if *target != "" && !strings.EqualFold(*target, hash) {
+			return usagef("-target %v does not match the target %v of the public key and salt", *target, hash)
+		}
+
+		if o.timeout <= 0 {
+			o.timeout = defaultGetTimeout
+		}
+		ctx, cancel := o.context()
+		defer cancel()
+		return o.withDHT(ctx, nil, func(n *network.DHT) error {
+			var r *network.GetResult
+			err := retryGet(ctx, n, hash, func() (err error) {
+				r, err = n.GetLatest(ctx, hash, pbk, *seq, *salt, 0)
+				return
+			})
+			if err != nil {
+				return err
+			}
+			lg.Log(network.LevelInfo, "got value", "target", hash, "seq", r.Seq, "size", len(r.Value))
+			if err := o.checkRaw(r.Value, encoding); err != nil {
+				return err
+			}
+			o.result(newGetResult(r, encoding))
+			return nil
+		})
+	}
+}
+
+func getImmutable(o *options, target, encoding string) error {
+	target = strings.ToLower(target)
+	if o.timeout <= 0 {
+		o.timeout = defaultGetTimeout
+	}
+	ctx, cancel := o.context()
+	defer cancel()
+	return o.withDHT(ctx, nil, func(n *network.DHT) error {
+		var val string
+		err := retryGet(ctx, n, target, func() (err error) {
+			val, err = n.GetImmutableContext(ctx, target)
+			return
+		})
+		if err != nil {
+			return err
+		}
+		if err := o.checkRaw(val, encoding); err != nil {
+			return err
+		}
+		o.result(getResult{Target: target, Immutable: true, Encoding: encoding, Value: encodeValue(val, encoding)})
+		return nil
+	})
+}
+
+const (
+	// defaultGetTimeout is the timeout of get when the global -timeout is not set.
+	defaultGetTimeout = time.Minute
+	// getRetryDelay is the delay before asking again for a value not found, it doubles up to maxGetRetryDelay.
+	getRetryDelay    = time.Second
+	maxGetRetryDelay = 10 * time.Second
+)
+
+// retryGet calls get until it succeeds, fails with another error than a value not found yet, or ctx is done.
+// The stores of target are looked up again before every retry so nodes which joined since are asked.
+// Once the deadline of ctx expires it fails with a network.ErrValueNotFound error.
+func retryGet(ctx context.Context, n *network.DHT, target string, get func() error) error {
+	delay := getRetryDelay
+	for {
+		err := get()
+		switch {
+		case err == nil:
+			return nil
+		case ctx.Err() == context.DeadlineExceeded && (retryable(err) || errors.Is(err, context.DeadlineExceeded)):
+			return notFound(target, err)
+		case ctx.Err() != nil || !retryable(err):
+			return err
+		}
+		lg.Log(network.LevelInfo, "value not found yet", "target", target, "retry_in", delay, "err", err)
+		n.ForgetStores(target)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				return notFound(target, err)
+			}
+			return err
+		}
+		if delay *= 2; delay > maxGetRetryDelay {
+			delay = maxGetRetryDelay
+		}
+	}
+}
+
+// notFound is the error of a get whose deadline expired before a value was found,
+// with the node errors of the last attempt.
+func notFound(target string, last error) error {
+	ret := &network.Error{Op: fmt.Sprintf("retrieving %v timed out", target), Kind: network.ErrValueNotFound}
+	var nerr *network.Error
+	if errors.As(last, &nerr) {
+		ret.Nodes = nerr.Nodes
+	}
+	return ret
+}
+
+// retryable returns true if err may be a value not stored yet, or not reachable yet.
+func retryable(err error) bool {
+	return errors.Is(err, network.ErrValueNotFound) ||
+		errors.Is(err, network.ErrNoStoresFound) ||
+		errors.Is(err, network.ErrTimeout)
+}
+
+func watch(fs *flag.FlagSet) func(o *options) error {
+	pubkey := fs.String("pubkey", "", "hex public key of the value, the local public key when not set")
+	salt := fs.String("salt", "", "salt of the mutable value")
+	interval := fs.Duration("interval", 10*time.Second, "polling interval")
+	enc := outputEncodingFlag(fs, "encoding of the values written")
+	return func(o *options) error {
+		if *interval <= 0 {
+			return usagef("-interval must be positive")
+		}
+		encoding, err := o.outputEncoding(*enc)
+		if err != nil {
+			return err
+		}
+		pbk, err := o.publicKey(*pubkey)
+		if err != nil {
+			return err
+		}
+		ctx, cancel := o.context()
+		defer cancel()
+		return o.withDHT(ctx, nil, func(n *network.DHT) error {
+			c, err := n.Watch(ctx, pbk, *salt, *interval)
+			if err != nil {
+				return err
+			}
+			for r := range c {
+				if err := o.checkRaw(r.Value, encoding); err != nil {
+					return err
+				}
+				o.result(newGetResult(r, encoding))
+			}
+			return nil
+		})
+	}
+}
+
+func keys(fs *flag.FlagSet) func(o *options) error {
+	generate := fs.Bool("generate", false, "create the private key, it fails if the key exists")
+	return func(o *options) error {
+		if *generate {
+			if err := generateKey(o.keyFile()); err != nil {
+				return err
+			}
+			lg.Log(network.LevelInfo, "private key created", "file", o.keyFile())
+		}
+		_, public, err := o.keys()
+		if err != nil {
+			return err
+		}
+		o.result(keysResult{File: o.keyFile(), PublicKey: hexStr(public), Generated: *generate})
+		return nil
+	}
+}
+
+// generateKey writes a new private key to file, readable by its owner only.
+// The vendored ed25519 signs with the hashed form of the key, the SHA512 of a random seed
+// clamped as in RFC 8032, an unclamped key signs values the nodes reject.
+func generateKey(file string) error {
+	if _, err := os.Stat(file); err == nil {
+		return fmt.Errorf("private key %v already exists", file)
+	}
+	seed := make([]byte, 32)
+	if _, err := rand.Read(seed); err != nil {
+		return err
+	}
+	pvk := sha512.Sum512(seed)
+	pvk[0] &= 248
+	pvk[31] &= 127
+	pvk[31] |= 64
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, []byte(hex.EncodeToString(pvk[:])), 0600)
+}
+
+func serve(fs *flag.FlagSet) func(o *options) error {
+	return func(o *options) error {
+		ctx, cancel := o.context()
+		defer cancel()
+		return o.withDHT(ctx, nil, func(n *network.DHT) error {
+			o.result(serveResult{Addr: n.Addr().String(), ID: n.ID(), Nodes: len(n.Nodes())})
+			<-ctx.Done()
+			return nil
+		})
+	}
+}
+
+func nodes(fs *flag.FlagSet) func(o *options) error {
+	return func(o *options) error {
+		ctx, cancel := o.context()
+		defer cancel()
+		return o.withDHT(ctx, nil, func(n *network.DHT) error {
+			r := nodesResult{Nodes: []string{}}
+			for _, addr := range n.Nodes() {
+				r.Nodes = append(r.Nodes, addr.String())
+			}
+			o.result(r)
+			return nil
+		})
+	}
+}
+
+// publicKey decodes hexKey, the public key of the private key when empty.
+func (o *options) publicKey(hexKey string) (cryptoed25519.PublicKey, error) {
+	if hexKey != "" {
+		pbk, err := parsePublicKey(hexKey)
+		if err != nil {
+			return nil, usagef("invalid -pubkey: %v", err)
+		}
+		return pbk, nil
+	}
+	_, public, err := o.keys()
+	return public, err
+}
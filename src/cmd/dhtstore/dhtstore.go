@@ -1,11 +1,19 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"fmt"
+	"io"
 	"log"
 	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/Ecsy/dhtstore/src/network"
@@ -20,151 +28,338 @@ const (
 	keyName = "dht"
 )
 
+// Exit codes of the program.
+const (
+	exitOK       = 0
+	exitFailure  = 1 // the command failed
+	exitUsage    = 2 // the command line is invalid
+	exitNotFound = 3 // the value was not found in the DHT network
+)
+
 var (
 	// Program version information.
 	version string
 	// Program build date.
 	buildDate string
+
+	// lg is the program logger, set up by the -log-level and -log-format flags.
+	lg = network.NewStdLogger(log.New(os.Stderr, "", stdLogFlags), network.LevelInfo)
 )
 
-func main() {
-	log.SetFlags(log.LstdFlags | log.Lmicroseconds | log.Lshortfile | log.LUTC)
-	log.Printf("program: %s, version: %s, build date: %s\n", os.Args[0], version, buildDate)
-
-	var (
-		action = flag.String("action", "sign", "program action. valid options are: sign, get, put")
-		value  = flag.String("value", "", "")
-		seq    = flag.Int("seq", 1, "")
-		salt   = flag.String("salt", "", "")
-		target = flag.String("target", "", "")
-	)
+const stdLogFlags = log.LstdFlags | log.Lmicroseconds | log.LUTC
 
-	flag.Parse()
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
 
-	privateKey, publicKey, err := getKeys()
+// run runs the command line args and returns the exit code.
+func run(args []string) int {
+	o := &options{in: os.Stdin, out: os.Stdout}
+	global := flag.NewFlagSet("dhtstore", flag.ContinueOnError)
+	o.register(global)
+	global.Usage = func() { usage(global) }
+	err := global.Parse(args)
+	if err == flag.ErrHelp {
+		return exitOK
+	}
+	// checked first so the errors below are written as JSON documents with -output json.
+	if o.output != "text" && o.output != "json" {
+		uerr := usagef("invalid -output %q, expected text or json", o.output)
+		fmt.Fprintf(os.Stderr, "dhtstore: %v\n", uerr)
+		// a caller not asking for text reads JSON documents.
+		o.output = "json"
+		o.fail(uerr)
+		return exitUsage
+	}
 	if err != nil {
-		log.Fatal(err)
+		o.fail(usageError(err.Error()))
+		return exitUsage
+	}
+	if global.NArg() == 0 {
+		usage(global)
+		o.fail(usagef("no command"))
+		return exitUsage
 	}
-	log.Printf("Public Key: %x\n", publicKey)
-	log.Printf("Private Key: %x...%x\n", privateKey[:4], privateKey[60:])
-	_, _ = privateKey, publicKey
-
-	var readyFn func(public *dht.DHT) error
 
-	switch *action {
-	case "sign":
-		m, err := network.MutableTarget(privateKey, *value, *seq, *salt)
-		if err != nil {
-			log.Fatal(err)
+	name, args := global.Arg(0), global.Args()[1:]
+	if name == "help" {
+		if len(args) == 0 {
+			usage(global)
+			return exitOK
 		}
-		log.Printf("hash: %s\n", m.Target)
-		log.Printf("signature: %x\n", m.Sign)
-		return
-
-	case "get":
-		if len(*target) != 40 {
-			log.Fatal("please specifiy a valid target")
+		name, args = args[0], []string{"-h"}
+	}
+	cmd := findCommand(name)
+	if cmd == nil {
+		err := usagef("unknown command %q, run dhtstore help", name)
+		fmt.Fprintf(os.Stderr, "dhtstore: %v\n", err)
+		o.command = name
+		o.fail(err)
+		return exitUsage
+	}
+	fs := flag.NewFlagSet("dhtstore "+cmd.name, flag.ContinueOnError)
+	fs.Usage = func() { cmd.usage(fs) }
+	runCmd := cmd.setup(fs)
+	o.command = cmd.name
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return exitOK
 		}
-		readyFn = get(publicKey, *target, *seq, *salt)
+		o.fail(usageError(err.Error()))
+		return exitUsage
+	}
+	if fs.NArg() > 0 {
+		err := usagef("unexpected arguments %q", fs.Args())
+		fmt.Fprintf(os.Stderr, "dhtstore %v: %v\n", cmd.name, err)
+		fs.Usage()
+		o.fail(err)
+		return exitUsage
+	}
+	if err := o.init(); err != nil {
+		fmt.Fprintf(os.Stderr, "dhtstore: %v\n", err)
+		o.fail(usageError(err.Error()))
+		return exitUsage
+	}
+	lg.Log(network.LevelDebug, "starting", "program", os.Args[0], "version", version, "build_date", buildDate, "command", cmd.name)
+	err = runCmd(o)
+	o.fail(err)
+	return exit(cmd, err)
+}
 
-	case "put":
-		readyFn = put(privateKey, *value, *seq, *salt)
+// exit logs the error of cmd and returns its exit code.
+func exit(cmd *command, err error) int {
+	var uerr usageError
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &uerr):
+		fmt.Fprintf(os.Stderr, "dhtstore %v: %v\nrun dhtstore help %v for usage\n", cmd.name, err, cmd.name)
+		return exitUsage
+	case errors.Is(err, network.ErrValueNotFound):
+		lg.Log(network.LevelError, cmd.name+" failed", "err", err)
+		return exitNotFound
+	}
+	lg.Log(network.LevelError, cmd.name+" failed", "err", err)
+	return exitFailure
+}
 
-	default:
-		log.Fatal("Invalid program action")
+// noKeyError is the directory missing the private key.
+type noKeyError string
+
+func (e noKeyError) Error() string {
+	return fmt.Sprintf("no private key in %v, create one with: dhtstore -keys %v keys -generate", filepath.Join(string(e), keyName+".key"), string(e))
+}
+
+// usageError is an invalid command line.
+type usageError string
+
+func usagef(format string, args ...interface{}) error {
+	return usageError(fmt.Sprintf(format, args...))
+}
+
+func (e usageError) Error() string {
+	return string(e)
+}
+
+func usage(global *flag.FlagSet) {
+	w := global.Output()
+	fmt.Fprintf(w, "dhtstore stores signed values in the BitTorrent DHT network, version %v.\n\n", version)
+	fmt.Fprintf(w, "Usage:\n\n  dhtstore [global options] <command> [options]\n\nCommands:\n\n")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-8v %v\n", c.name, c.short)
 	}
+	fmt.Fprintf(w, "\nRun dhtstore help <command> for the options of a command.\n\nGlobal options:\n\n")
+	global.PrintDefaults()
+	fmt.Fprintf(w, "\nExit codes: %d success, %d failure, %d invalid command line, %d value not found.\n",
+		exitOK, exitFailure, exitUsage, exitNotFound)
+}
 
-	hostname, err := os.Hostname()
-	if err != nil {
-		log.Panic(err)
+// command is a dhtstore subcommand.
+type command struct {
+	name  string
+	args  string // synopsis of the options
+	short string // one line description
+	long  string // help text
+	// setup declares the options of the command on fs and returns the function running it once fs is parsed.
+	setup func(fs *flag.FlagSet) func(o *options) error
+}
+
+func (c *command) usage(fs *flag.FlagSet) {
+	w := fs.Output()
+	fmt.Fprintf(w, "Usage: dhtstore [global options] %v %v\n\n%v\n", c.name, c.args, c.long)
+	fmt.Fprintf(w, "\nOptions:\n\n")
+	fs.PrintDefaults()
+}
+
+func findCommand(name string) *command {
+	for _, c := range commands {
+		if c.name == name {
+			return c
+		}
 	}
+	return nil
+}
 
-	var pip *net.IP
-	i := security.GenerateSecureNodeID(hostname, nil, pip)
-	socket := socket.NewConcurrent(24)
-	//socket.AddLogger(logger.Text(log.Printf))
+// options are the global options shared by the commands.
+type options struct {
+	keyDir        string
+	bootstrapFile string
+	listen        string
+	timeout       time.Duration
+	routers       string
+	private       bool
+	networkID     string
+	metrics       string
+	logLevel      string
+	logFormat     string
+	output        string
+
+	level   network.Level
+	command string    // name of the running command
+	in      io.Reader // read by put -file -
+	out     io.Writer // results of the commands
+}
 
-	opts := make([]dht.Opt, 0)
-	opts = append(opts, dht.Opts.WithRPCSocket(socket))
-	opts = append(opts, dht.Opts.WithAddr(""))
-	opts = append(opts, dht.Opts.ID(string(i)))
-	opts = append(opts, dht.Opts.WithConcurrency(8))
-	opts = append(opts, dht.Opts.WithK(20))
+func (o *options) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.keyDir, "keys", ".", "directory of the dht.key private key")
+	fs.StringVar(&o.bootstrapFile, "bootstrap", "bootstrap.json", "file saving the nodes to bootstrap from, empty to bootstrap from the routers every time, not used with -routers or -private")
+	fs.StringVar(&o.listen, "listen", "", "UDP address of the DHT node, a random port when not set")
+	fs.DurationVar(&o.timeout, "timeout", 0, "abort the command after this duration, 0 for no limit except for get which gives up after 1m")
+	fs.StringVar(&o.routers, "routers", "", "comma separated addresses to bootstrap from instead of the bootstrap file and the public routers")
+	fs.BoolVar(&o.private, "private", false, "never bootstrap from the public BitTorrent routers nor the bootstrap file")
+	fs.StringVar(&o.networkID, "network-id", "", "private network ID mixed into the targets of mutable values")
+	fs.StringVar(&o.metrics, "metrics", "", "serve Prometheus metrics on this address, e.g. :9100")
+	fs.StringVar(&o.logLevel, "log-level", "info", "minimum level of the logs: debug, info, warn or error, debug logs the DHT traffic")
+	fs.StringVar(&o.logFormat, "log-format", "text", "format of the logs: text or json")
+	fs.StringVar(&o.output, "output", "text", "format of the results written to stdout: text, or json for one JSON document per result")
+}
 
-	node := dht.New(opts...)
-	if err := node.ListenAndServe(dht.StdQueryHandler(node), readyFn); err != nil {
-		log.Fatal(err)
+// init checks the options and sets up the logger, -output is checked by run.
+func (o *options) init() (err error) {
+	if o.level, err = network.ParseLevel(o.logLevel); err != nil {
+		return err
 	}
+	switch o.logFormat {
+	case "text":
+		lg = network.NewStdLogger(log.New(os.Stderr, "", stdLogFlags), o.level)
+	case "json":
+		lg = network.NewJSONLogger(os.Stderr, o.level)
+	default:
+		return fmt.Errorf("invalid -log-format %q", o.logFormat)
+	}
+	return nil
 }
 
-func get(publicKey cryptoed25519.PublicKey, target string, seq int, salt string) func(*dht.DHT) error {
-	return func(public *dht.DHT) error {
-		// DHT bootstrap
-		n := network.NewDHT(public, log.New(os.Stderr, "", log.Flags()))
-		_, err := n.Bootstrap("bootstrap.json")
-		if err != nil {
-			return err
-		}
+// config returns the network selected by the options.
+func (o *options) config() network.NetworkConfig {
+	config := network.NetworkConfig{
+		NoPublicFallback: o.private,
+		NetworkID:        o.networkID,
+	}
+	if o.routers != "" {
+		config.Bootstrap = strings.Split(o.routers, ",")
+	}
+	return config
+}
 
-		for {
-			val, err := n.Get(target, publicKey, seq, salt)
-			if err != nil {
-				if err == network.ErrValueNotFound {
-					log.Println(err)
-					continue
-				}
-				log.Fatal(err)
-			}
-			log.Printf("seq: %d, val: %s\n", seq, val)
-			if idx := strings.Index(val, " m="); idx > -1 {
-				val = val[:idx]
-			}
-			t, err := time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", val)
-			if err == nil {
-				log.Printf("seen: %s\n", time.Now().Sub(t))
-			}
+// context returns a context done on SIGINT, SIGTERM or after the timeout.
+func (o *options) context() (context.Context, context.CancelFunc) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if o.timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), o.timeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case s := <-sig:
+			lg.Log(network.LevelInfo, "stopping", "signal", s)
+			cancel()
+		case <-ctx.Done():
 		}
+		signal.Stop(sig)
+	}()
+	return ctx, cancel
+}
+
+// keyFile returns the path of the private key.
+func (o *options) keyFile() string {
+	return filepath.Join(o.keyDir, keyName+".key")
+}
 
-		return nil
+// keys loads the private key, it fails if the key does not exist.
+func (o *options) keys() (ed25519.PrivateKey, cryptoed25519.PublicKey, error) {
+	if _, err := os.Stat(o.keyFile()); os.IsNotExist(err) {
+		return nil, nil, noKeyError(o.keyDir)
 	}
+	private, public, err := ed25519.PvkFromDir(o.keyDir, keyName)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Fix ed25519.PvkFromDir returning longer private key than expected.
+	return private[:64], public, nil
 }
 
-func put(privateKey ed25519.PrivateKey, value string, seq int, salt string) func(*dht.DHT) error {
-	return func(public *dht.DHT) error {
-		// DHT bootstrap
-		n := network.NewDHT(public, log.New(os.Stderr, "", log.Flags()))
-		_, err := n.Bootstrap("bootstrap.json")
-		if err != nil {
-			return err
-		}
+// withDHT starts a DHT node, bootstraps it, keeps its routing table filled and calls fn.
+func (o *options) withDHT(ctx context.Context, opts []network.Opt, fn func(n *network.DHT) error) error {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	var pip *net.IP
+	i := security.GenerateSecureNodeID(hostname, nil, pip)
 
-		if value == "" {
-			value = time.Now().String()
-		}
+	sock := socket.NewConcurrent(24)
+	if o.level == network.LevelDebug {
+		sock.AddLogger(network.TrafficLogger(lg))
+	}
+	opts = append([]network.Opt{network.Opts.WithNetwork(o.config())}, opts...)
+	if o.metrics != "" {
+		m := network.NewMetrics()
+		opts = append(opts, network.Opts.WithMetrics(m, sock.GetPeersStats()))
+		go serveMetrics(o.metrics, m)
+	}
 
-		m, err := network.MutableTarget(privateKey, value, seq, salt)
-		if err != nil {
-			return err
-		}
-		log.Printf("put target hash: %v\n", m.Target)
-		t := time.Now()
-		err = n.Put(m)
-		if err != nil {
+	node := dht.New(
+		dht.Opts.WithRPCSocket(sock),
+		dht.Opts.WithAddr(o.listen),
+		dht.Opts.ID(string(i)),
+		dht.Opts.WithConcurrency(8),
+		dht.Opts.WithK(20),
+	)
+	defer node.Close()
+	return node.ListenAndServe(network.NewQueryHandler(node), func(public *dht.DHT) error {
+		n := network.NewDHT(public, lg, opts...)
+		s := network.NewBootstrapSupervisor(n, o.bootstrapFile, 0, 0)
+		if err := s.Bootstrap(ctx); err != nil {
 			return err
 		}
+		go s.Run(ctx)
+		return fn(n)
+	})
+}
 
-		log.Printf("put done %s", time.Now().Sub(t))
-		return nil
+// serveMetrics serves m on addr at /metrics until the program is stopped.
+func serveMetrics(addr string, m *network.Metrics) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	lg.Log(network.LevelInfo, "serving metrics", "addr", addr, "path", "/metrics")
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		lg.Log(network.LevelError, "serving metrics failed", "addr", addr, "err", err)
 	}
 }
 
-func getKeys() (ed25519.PrivateKey, cryptoed25519.PublicKey, error) {
-	// Private and public key, generate if not found.
-	private, public, err := ed25519.PvkFromDir(".", keyName)
+// parsePublicKey decodes an hex ed25519 public key.
+func parsePublicKey(s string) (cryptoed25519.PublicKey, error) {
+	k, err := ed25519.PbkFromHex(s)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	// Fix ed25519.PvkFromDir returning longer private key than expected.
-	private = private[:64]
-	return private, public, err
+	if len(k) != cryptoed25519.PublicKeySize {
+		return nil, fmt.Errorf("public key is %d bytes long, expected %d", len(k), cryptoed25519.PublicKeySize)
+	}
+	return k, nil
 }
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/Ecsy/dhtstore/src/network"
+)
+
+// document is the JSON document written by a command with -output json.
+// Exactly one of Result and Error is set.
+type document struct {
+	Command string       `json:"command"`
+	OK      bool         `json:"ok"`
+	Result  interface{}  `json:"result,omitempty"`
+	Error   *errorObject `json:"error,omitempty"`
+}
+
+// errorObject is a failed command, Type is one of the errorTypes names,
+// "usage", "no_key", "timeout", "canceled" or "error".
+type errorObject struct {
+	Type    string       `json:"type"`
+	Message string       `json:"message"`
+	Nodes   []nodeObject `json:"nodes,omitempty"`
+}
+
+// nodeObject is the KRPC error answered by a node.
+type nodeObject struct {
+	Addr    string `json:"addr"`
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// errorTypes are the types of the errors of the network package.
+var errorTypes = []struct {
+	kind error
+	name string
+}{
+	{network.ErrValueNotFound, "value_not_found"},
+	{network.ErrNoStoresFound, "no_stores_found"},
+	{network.ErrTimeout, "nodes_timed_out"},
+	{network.ErrInvalidSignature, "invalid_signature"},
+	{network.ErrSeqTooOld, "seq_too_old"},
+	{network.ErrCasMismatch, "cas_mismatch"},
+	{network.ErrValueTooLong, "value_too_long"},
+	{network.ErrSaltTooLong, "salt_too_long"},
+	{network.ErrRejected, "rejected"},
+	{network.ErrUnderReplicated, "under_replicated"},
+	{network.ErrNoQuorum, "no_quorum"},
+	{network.ErrDecrypt, "decrypt"},
+	{network.ErrBrokenChunks, "broken_chunks"},
+}
+
+func newErrorObject(err error) *errorObject {
+	ret := &errorObject{Type: "error", Message: err.Error()}
+	var uerr usageError
+	var kerr noKeyError
+	var nerr *network.Error
+	switch {
+	case errors.As(err, &uerr):
+		ret.Type = "usage"
+	case errors.As(err, &kerr):
+		ret.Type = "no_key"
+	case errors.Is(err, context.DeadlineExceeded):
+		ret.Type = "timeout"
+	case errors.Is(err, context.Canceled):
+		ret.Type = "canceled"
+	case errors.As(err, &nerr):
+		for _, t := range errorTypes {
+			if nerr.Kind == t.kind {
+				ret.Type = t.name
+			}
+		}
+		for _, n := range nerr.Nodes {
+			ret.Nodes = append(ret.Nodes, nodeObject{Addr: fmt.Sprint(n.Addr), Code: n.Err.Code, Message: n.Err.Msg})
+		}
+	}
+	return ret
+}
+
+// textResult is a result written as "name: value" lines with -output text.
+type textResult interface {
+	writeText(w io.Writer)
+}
+
+// result writes the result of the command.
+func (o *options) result(r textResult) {
+	if o.output == "json" {
+		o.writeDocument(document{Command: o.command, OK: true, Result: r})
+		return
+	}
+	r.writeText(o.out)
+}
+
+// fail writes the error of the command with -output json, errors are only logged with -output text.
+func (o *options) fail(err error) {
+	if err != nil && o.output == "json" {
+		o.writeDocument(document{Command: o.command, Error: newErrorObject(err)})
+	}
+}
+
+func (o *options) writeDocument(d document) {
+	data, err := json.Marshal(d)
+	if err != nil {
+		lg.Log(network.LevelError, "encoding output failed", "err", err)
+		return
+	}
+	fmt.Fprintf(o.out, "%s\n", data)
+}
+
+type signResult struct {
+	Target    string `json:"target"`
+	PublicKey hexStr `json:"public_key"`
+	Salt      string `json:"salt"`
+	Seq       int    `json:"seq"`
+	Signature hexStr `json:"signature"`
+}
+
+func (r signResult) writeText(w io.Writer) {
+	fmt.Fprintf(w, "target: %v\nseq: %d\nsignature: %v\n", r.Target, r.Seq, r.Signature)
+}
+
+type putResult struct {
+	Target    string `json:"target"`
+	Immutable bool   `json:"immutable"`
+	PublicKey hexStr `json:"public_key,omitempty"`
+	Salt      string `json:"salt"`
+	Seq       int    `json:"seq"`
+	Size      int    `json:"size"`      // bytes of the decoded value
+	Accepted  int    `json:"accepted"`  // nodes which stored the value
+	Rejected  int    `json:"rejected"`  // nodes which answered an error
+	TimedOut  int    `json:"timed_out"` // nodes which did not answer
+}
+
+// newPutResult completes ret with the target and the node counts of r.
+func newPutResult(r *network.PutResult, ret putResult) putResult {
+	ret.Target = r.Target
+	ret.Accepted, ret.Rejected, ret.TimedOut = len(r.Accepted), len(r.Rejected), len(r.TimedOut)
+	return ret
+}
+
+func (r putResult) writeText(w io.Writer) {
+	fmt.Fprintf(w, "target: %v\n", r.Target)
+	if !r.Immutable {
+		fmt.Fprintf(w, "seq: %d\n", r.Seq)
+	}
+}
+
+type getResult struct {
+	Target    string `json:"target"`
+	Immutable bool   `json:"immutable"`
+	PublicKey hexStr `json:"public_key,omitempty"`
+	Salt      string `json:"salt"`
+	Seq       int    `json:"seq"`
+	Encoding  string `json:"encoding"` // encoding of Value
+	Value     string `json:"value"`
+}
+
+func newGetResult(r *network.GetResult, encoding string) getResult {
+	return getResult{Target: r.Target, PublicKey: r.PublicKey, Salt: r.Salt, Seq: r.Seq, Encoding: encoding, Value: encodeValue(r.Value, encoding)}
+}
+
+func (r getResult) writeText(w io.Writer) {
+	if !r.Immutable {
+		fmt.Fprintf(w, "seq: %d\n", r.Seq)
+	}
+	fmt.Fprintf(w, "value: %s\n", r.Value)
+}
+
+type keysResult struct {
+	File      string `json:"file"`
+	PublicKey hexStr `json:"public_key"`
+	Generated bool   `json:"generated"`
+}
+
+func (r keysResult) writeText(w io.Writer) {
+	fmt.Fprintf(w, "file: %v\npublic key: %v\n", r.File, r.PublicKey)
+}
+
+type nodesResult struct {
+	Nodes []string `json:"nodes"`
+}
+
+func (r nodesResult) writeText(w io.Writer) {
+	for _, n := range r.Nodes {
+		fmt.Fprintln(w, n)
+	}
+}
+
+type serveResult struct {
+	Addr  string `json:"addr"`
+	ID    hexStr `json:"id"`
+	Nodes int    `json:"nodes"`
+}
+
+func (r serveResult) writeText(w io.Writer) {
+	fmt.Fprintf(w, "addr: %v\nid: %v\nnodes: %d\n", r.Addr, r.ID, r.Nodes)
+}
+
+// hexStr is a byte slice written in hex.
+type hexStr []byte
+
+func (h hexStr) String() string {
+	return fmt.Sprintf("%x", []byte(h))
+}
+
+func (h hexStr) MarshalText() ([]byte, error) {
+	return []byte(h.String()), nil
+}
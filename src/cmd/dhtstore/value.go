@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"unicode/utf8"
+
+	"github.com/Ecsy/dhtstore/src/network"
+)
+
+// encodings are the encodings of the values read by put and written by get.
+var encodings = []string{"raw", "base64", "hex"}
+
+func encodingFlag(fs *flag.FlagSet, usage string) *string {
+	return fs.String("encoding", "raw", usage+": raw, base64 or hex")
+}
+
+// outputEncodingFlag declares the -encoding of the values written by get and watch, see options.outputEncoding.
+func outputEncodingFlag(fs *flag.FlagSet, usage string) *string {
+	return fs.String("encoding", "", usage+": raw, base64 or hex, raw with -output text and base64 with -output json when not set")
+}
+
+// outputEncoding returns the encoding of the values written, encoding when set.
+// JSON strings can not hold binary values, they are written in base64 with -output json by default.
+func (o *options) outputEncoding(encoding string) (string, error) {
+	if encoding != "" {
+		return encoding, checkEncoding(encoding)
+	}
+	if o.output == "json" {
+		return "base64", nil
+	}
+	return "raw", nil
+}
+
+// checkRaw fails if value is written raw with -output json and is not valid UTF-8,
+// encoding/json would replace its invalid bytes.
+func (o *options) checkRaw(value, encoding string) error {
+	if o.output == "json" && encoding == "raw" && !utf8.ValidString(value) {
+		return usagef("the value is not valid UTF-8 and can not be written raw in JSON, use -encoding base64 or hex")
+	}
+	return nil
+}
+
+func checkEncoding(encoding string) error {
+	for _, e := range encodings {
+		if e == encoding {
+			return nil
+		}
+	}
+	return usagef("invalid -encoding %q, expected raw, base64 or hex", encoding)
+}
+
+// valueFlags are the options giving the value to sign or put.
+type valueFlags struct {
+	value    *string
+	file     *string
+	encoding *string
+}
+
+func newValueFlags(fs *flag.FlagSet) valueFlags {
+	return valueFlags{
+		value:    fs.String("value", "", "value"),
+		file:     fs.String("file", "", "read the value from this file, - for stdin"),
+		encoding: encodingFlag(fs, "encoding of -value or -file, the decoded bytes are stored"),
+	}
+}
+
+// read returns the decoded value of -value or -file, stdin is read for -file -.
+func (f valueFlags) read(stdin io.Reader) (string, error) {
+	if err := checkEncoding(*f.encoding); err != nil {
+		return "", err
+	}
+	var data []byte
+	switch {
+	case *f.value != "" && *f.file != "":
+		return "", usagef("-value and -file are exclusive")
+	case *f.value != "":
+		data = []byte(*f.value)
+	case *f.file == "-":
+		var err error
+		if data, err = ioutil.ReadAll(stdin); err != nil {
+			return "", fmt.Errorf("reading stdin failed: %v", err)
+		}
+	case *f.file != "":
+		var err error
+		if data, err = ioutil.ReadFile(*f.file); err != nil {
+			return "", err
+		}
+	default:
+		return "", usagef("no value, set -value or -file")
+	}
+	return decodeValue(data, *f.encoding)
+}
+
+// decodeValue decodes data given in encoding, spaces around base64 and hex data are ignored.
+func decodeValue(data []byte, encoding string) (string, error) {
+	var ret []byte
+	var err error
+	switch encoding {
+	case "base64":
+		ret, err = base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data)))
+	case "hex":
+		ret, err = hex.DecodeString(string(bytes.TrimSpace(data)))
+	default:
+		return string(data), nil
+	}
+	if err != nil {
+		return "", usagef("invalid %v value: %v", encoding, err)
+	}
+	return string(ret), nil
+}
+
+// encodeValue encodes value in encoding.
+func encodeValue(value, encoding string) string {
+	switch encoding {
+	case "base64":
+		return base64.StdEncoding.EncodeToString([]byte(value))
+	case "hex":
+		return hex.EncodeToString([]byte(value))
+	}
+	return value
+}
+
+// checkPut fails with a network.ErrValueTooLong or network.ErrSaltTooLong error
+// if the nodes would reject value or salt. Values are not limited when chunked.
+// An empty value, as read from an empty file, is a usage error.
+func checkPut(value, salt string, chunked bool) error {
+	if len(value) == 0 {
+		return usagef("value is empty, nodes do not store empty values")
+	}
+	if len(salt) > network.MaxSaltLen {
+		return &network.Error{Op: fmt.Sprintf("salt is %d bytes long, at most %d are stored", len(salt), network.MaxSaltLen), Kind: network.ErrSaltTooLong}
+	}
+	if !chunked && len(value) > network.MaxValueLen {
+		return &network.Error{Op: fmt.Sprintf("value is %d bytes long, at most %d are stored", len(value), network.MaxValueLen), Kind: network.ErrValueTooLong}
+	}
+	return nil
+}
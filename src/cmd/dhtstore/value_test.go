@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/Ecsy/dhtstore/src/network"
+)
+
+func TestDecodeValue(t *testing.T) {
+	tests := []struct {
+		data     string
+		encoding string
+		want     string
+		usage    bool
+	}{
+		{"héllo\n", "raw", "héllo\n", false},
+		{" aGVsbG8=\n", "base64", "hello", false},
+		{"68656c6c6f\n", "hex", "hello", false},
+		{"00ff", "hex", "\x00\xff", false},
+		{"aGVsbG8", "base64", "", true},
+		{"6g", "hex", "", true},
+	}
+	for _, tt := range tests {
+		got, err := decodeValue([]byte(tt.data), tt.encoding)
+		var uerr usageError
+		if tt.usage {
+			if !errors.As(err, &uerr) {
+				t.Errorf("%s %q: got error %v, want a usage error", tt.encoding, tt.data, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s %q: got %q, %v, want %q", tt.encoding, tt.data, got, err, tt.want)
+		}
+		if back, err := decodeValue([]byte(encodeValue(got, tt.encoding)), tt.encoding); err != nil || back != got {
+			t.Errorf("%s %q: encoded value decoded to %q, %v", tt.encoding, got, back, err)
+		}
+	}
+}
+
+func TestCheckPut(t *testing.T) {
+	long := strings.Repeat("v", network.MaxValueLen+1)
+	tests := []struct {
+		name    string
+		value   string
+		salt    string
+		chunked bool
+		kind    error // expected kind of the network.Error
+		usage   bool
+	}{
+		{"valid", "value", "salt", false, nil, false},
+		{"empty", "", "", false, nil, true},
+		{"empty chunked", "", "", true, nil, true},
+		{"too long", long, "", false, network.ErrValueTooLong, false},
+		{"long chunked", long, "", true, nil, false},
+		{"salt too long", "value", strings.Repeat("s", network.MaxSaltLen+1), true, network.ErrSaltTooLong, false},
+	}
+	for _, tt := range tests {
+		err := checkPut(tt.value, tt.salt, tt.chunked)
+		var uerr usageError
+		switch {
+		case tt.usage:
+			if !errors.As(err, &uerr) {
+				t.Errorf("%s: got error %v, want a usage error", tt.name, err)
+			}
+		case tt.kind != nil:
+			if !errors.Is(err, tt.kind) {
+				t.Errorf("%s: got error %v, want %v", tt.name, err, tt.kind)
+			}
+		case err != nil:
+			t.Errorf("%s: got error %v", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package network
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/mh-cbon/dht/dht"
+	"github.com/pkg/errors"
+)
+
+const (
+	// MaxValueLen is the longest value a node accepts in a put query (see rpc.CheckPutQuery).
+	MaxValueLen = 999
+	// MaxSaltLen is the longest salt a node accepts in a put query.
+	MaxSaltLen = 64
+	// chunkSize is the length of the chunks of a large value, it leaves room for the bencoded length.
+	chunkSize = 990
+	// manifestPrefix marks a mutable value listing the chunks of a large value.
+	manifestPrefix = "\x00dhtstore-chunks:"
+	// chunkConcurrency is the number of chunks stored or retrieved simultaneously.
+	chunkConcurrency = 4
+)
+
+// manifest lists the immutable chunks of a large value.
+type manifest struct {
+	Size   int      `bencode:"n"`
+	Chunks []string `bencode:"c"` // raw sha1 targets
+}
+
+// isManifest returns true if a mutable value is a manifest of chunks.
+func isManifest(v string) bool {
+	return strings.HasPrefix(v, manifestPrefix)
+}
+
+// packValue seals value when a Sealer is set, stores it as immutable chunks if it is longer than MaxValueLen
+// and returns the manifest to sign in place of value.
+func (d *DHT) packValue(ctx context.Context, value string) (string, error) {
+	if d.sealer != nil {
+		var err error
+		if value, err = d.sealer.Seal(value); err != nil {
+			return "", err
+		}
+	}
+	if len(value) <= MaxValueLen && !isManifest(value) {
+		return value, nil
+	}
+	packed, n, err := encodeManifest(value)
+	if err != nil {
+		return "", err
+	}
+
+	err = eachChunk(ctx, n, func(i int) error {
+		end := (i + 1) * chunkSize
+		if end > len(value) {
+			end = len(value)
+		}
+		_, err := d.PutImmutableContext(ctx, value[i*chunkSize:end])
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return packed, nil
+}
+
+// unpackValue reassembles a large value from the manifest v and opens it when a Sealer is set.
+func (d *DHT) unpackValue(ctx context.Context, v string) (string, error) {
+	v, err := d.unchunkValue(ctx, v)
+	if err != nil || d.sealer == nil {
+		return v, err
+	}
+	return d.sealer.Open(v)
+}
+
+// unchunkValue reassembles a large value from the manifest v,
+// a value which is not a manifest is returned as is.
+// A chunk not found, or a reassembled value of the wrong size, is an ErrBrokenChunks error:
+// the value was published and must not be taken for a value never published.
+func (d *DHT) unchunkValue(ctx context.Context, v string) (string, error) {
+	if !isManifest(v) {
+		return v, nil
+	}
+	m, err := decodeManifest(v)
+	if err != nil {
+		return "", err
+	}
+	chunks := make([]string, len(m.Chunks))
+	err = eachChunk(ctx, len(m.Chunks), func(i int) error {
+		// GetImmutable verifies the chunk hashes to its target.
+		c, err := d.GetImmutableContext(ctx, hex.EncodeToString([]byte(m.Chunks[i])))
+		if kindOf(err) == ErrValueNotFound {
+			return &Error{Op: fmt.Sprintf("retrieving chunk %d of %d failed", i+1, len(m.Chunks)), Kind: ErrBrokenChunks, Nodes: err.(*Error).Nodes}
+		}
+		chunks[i] = c
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	ret := strings.Join(chunks, "")
+	if len(ret) != m.Size {
+		return "", &Error{Op: fmt.Sprintf("reassembled value is %d bytes long, expected %d", len(ret), m.Size), Kind: ErrBrokenChunks}
+	}
+	return ret, nil
+}
+
+// refreshChunks puts again the chunks listed by the manifest v to fresh stores so they do not expire,
+// a value which is not a manifest has no chunk to refresh.
+func (d *DHT) refreshChunks(ctx context.Context, v string) error {
+	if !isManifest(v) {
+		return nil
+	}
+	m, err := decodeManifest(v)
+	if err != nil {
+		return err
+	}
+	return eachChunk(ctx, len(m.Chunks), func(i int) error {
+		target := hex.EncodeToString([]byte(m.Chunks[i]))
+		c, err := d.GetImmutableContext(ctx, target)
+		if err != nil {
+			return err
+		}
+		d.ForgetStores(target)
+		_, err = d.PutImmutableContext(ctx, c)
+		return err
+	})
+}
+
+// encodeManifest returns the manifest listing the chunks of value and their number.
+func encodeManifest(value string) (string, int, error) {
+	m := manifest{Size: len(value)}
+	for i := 0; i < len(value); i += chunkSize {
+		end := i + chunkSize
+		if end > len(value) {
+			end = len(value)
+		}
+		target, err := hex.DecodeString(dht.ValueToHex(value[i:end]))
+		if err != nil {
+			return "", 0, err
+		}
+		m.Chunks = append(m.Chunks, string(target))
+	}
+	b, err := bencode.Marshal(m)
+	if err != nil {
+		return "", 0, errors.Wrap(err, "encoding chunks manifest failed")
+	}
+	packed := manifestPrefix + string(b)
+	if len(packed) > MaxValueLen {
+		return "", 0, &Error{Op: fmt.Sprintf("chunking a value of %d bytes failed", len(value)), Kind: ErrValueTooLong}
+	}
+	return packed, len(m.Chunks), nil
+}
+
+func decodeManifest(v string) (m manifest, err error) {
+	if err = bencode.Unmarshal([]byte(v[len(manifestPrefix):]), &m); err != nil {
+		err = errors.Wrap(err, "decoding chunks manifest failed")
+	}
+	return
+}
+
+// eachChunk calls f for the n chunks with a limited concurrency, it returns the first error.
+func eachChunk(ctx context.Context, n int, f func(i int) error) error {
+	var wg sync.WaitGroup
+	var once sync.Once
+	var ret error
+	sem := make(chan struct{}, chunkConcurrency)
+	for i := 0; i < n; i++ {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return ctx.Err()
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := f(i); err != nil {
+				once.Do(func() { ret = err })
+			}
+		}(i)
+	}
+	wg.Wait()
+	return ret
+}
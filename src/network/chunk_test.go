@@ -0,0 +1,66 @@
+package network_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/Ecsy/dhtstore/src/network"
+	"github.com/Ecsy/dhtstore/src/testnet"
+	"github.com/mh-cbon/dht/ed25519"
+)
+
+func TestChunkedValue(t *testing.T) {
+	tn, err := testnet.New(8, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tn.Close()
+	d, err := tn.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	pvk := network.NewTestKey("chunks")
+	pbk := ed25519.PublicKeyFromPvk(pvk)
+
+	value := strings.Repeat("0123456789", 350)
+	m, err := d.PublishContext(ctx, pvk, "large", value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Val) > network.MaxValueLen {
+		t.Fatalf("signed value is %d bytes long, want a manifest", len(m.Val))
+	}
+	r, err := d.GetLatest(ctx, m.Target, pbk, 0, "large", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Value != value {
+		t.Fatalf("got a value of %d bytes, want the %d bytes published", len(r.Value), len(value))
+	}
+
+	// a manifest whose chunks were never stored.
+	manifest, _, err := network.EncodeManifest(strings.Repeat("9876543210", 200))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err = network.MutableTarget(pvk, manifest, 1, "missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.PutContext(ctx, m); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.GetLatest(ctx, m.Target, pbk, 0, "missing", 0); !errors.Is(err, network.ErrBrokenChunks) {
+		t.Errorf("get with missing chunks: got error %v, want %v", err, network.ErrBrokenChunks)
+	}
+	_, err = d.UpdateContext(ctx, pvk, "missing", func(old string, oldSeq int) (string, error) {
+		t.Errorf("update called with %q and seq %d, the value must not be reset", old, oldSeq)
+		return "", nil
+	})
+	if !errors.Is(err, network.ErrBrokenChunks) {
+		t.Errorf("update with missing chunks: got error %v, want %v", err, network.ErrBrokenChunks)
+	}
+}
@@ -0,0 +1,118 @@
+package network
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/mh-cbon/dht/dht"
+	"github.com/mh-cbon/dht/ed25519"
+	"github.com/pkg/errors"
+)
+
+// Codec encodes structured values to the strings stored in the DHT network.
+type Codec interface {
+	Marshal(v interface{}) (string, error)
+	Unmarshal(data string, v interface{}) error
+}
+
+var (
+	// JSON is a Codec using encoding/json, it is the default codec.
+	JSON Codec = jsonCodec{}
+	// Bencode is a Codec using the bittorrent encoding.
+	Bencode Codec = bencodeCodec{}
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+func (jsonCodec) Unmarshal(data string, v interface{}) error {
+	return json.Unmarshal([]byte(data), v)
+}
+
+type bencodeCodec struct{}
+
+func (bencodeCodec) Marshal(v interface{}) (string, error) {
+	b, err := bencode.Marshal(v)
+	return string(b), err
+}
+
+func (bencodeCodec) Unmarshal(data string, v interface{}) error {
+	return bencode.Unmarshal([]byte(data), v)
+}
+
+// Compressed returns a Codec compressing the output of c with deflate.
+func Compressed(c Codec) Codec {
+	return compressedCodec{c}
+}
+
+type compressedCodec struct {
+	Codec
+}
+
+func (c compressedCodec) Marshal(v interface{}) (string, error) {
+	data, err := c.Codec.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.BestCompression)
+	if err != nil {
+		return "", err
+	}
+	if _, err := w.Write([]byte(data)); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func (c compressedCodec) Unmarshal(data string, v interface{}) error {
+	r := flate.NewReader(bytes.NewBufferString(data))
+	defer r.Close()
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return errors.Wrap(err, "decompressing value failed")
+	}
+	return c.Codec.Unmarshal(string(b), v)
+}
+
+// PutValue encodes v with the DHT codec and publishes it under the private key and salt.
+func (d *DHT) PutValue(privateKey ed25519.PrivateKey, salt string, v interface{}) (*dht.MutablePut, error) {
+	return d.PutValueContext(context.Background(), privateKey, salt, v)
+}
+
+// PutValueContext is PutValue aborting with ctx.Err() once ctx is done.
+func (d *DHT) PutValueContext(ctx context.Context, privateKey ed25519.PrivateKey, salt string, v interface{}) (*dht.MutablePut, error) {
+	value, err := d.codec.Marshal(v)
+	if err != nil {
+		return nil, errors.Wrap(err, "encoding value failed")
+	}
+	return d.PublishContext(ctx, privateKey, salt, value)
+}
+
+// GetValue retrieves the latest value of the public key and salt and decodes it into out with the DHT codec.
+func (d *DHT) GetValue(publicKey []byte, salt string, out interface{}) error {
+	return d.GetValueContext(context.Background(), publicKey, salt, out)
+}
+
+// GetValueContext is GetValue aborting with ctx.Err() once ctx is done.
+func (d *DHT) GetValueContext(ctx context.Context, publicKey []byte, salt string, out interface{}) error {
+	r, err := d.GetLatest(ctx, d.Target(publicKey, salt), publicKey, 0, salt, 0)
+	if err != nil {
+		return err
+	}
+	if err := d.codec.Unmarshal(r.Value, out); err != nil {
+		return errors.Wrap(err, "decoding value failed")
+	}
+	return nil
+}
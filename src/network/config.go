@@ -0,0 +1,73 @@
+package network
+
+import (
+	"fmt"
+
+	"github.com/mh-cbon/dht/crypto"
+	"github.com/mh-cbon/dht/dht"
+	"github.com/mh-cbon/dht/ed25519"
+	"github.com/pkg/errors"
+)
+
+// PublicRouters lists the public BitTorrent routers to bootstrap from.
+var PublicRouters = []string{
+	"router.bittorrent.com:6881",
+	"router.utorrent.com:6881",
+}
+
+// NetworkConfig selects the DHT network a DHT joins.
+// The zero value joins the public DHT network.
+//
+// Only IPv4 is supported: the vendored DHT encodes nodes as BEP5 IPv4 compact node info,
+// ignores the BEP32 nodes6 field and resolves addresses as udp4.
+type NetworkConfig struct {
+	// Bootstrap lists the addresses to bootstrap from, the bootstrap file is ignored when it is set.
+	Bootstrap []string
+	// NoPublicFallback forbids bootstrapping from PublicRouters when Bootstrap is empty,
+	// the bootstrap file is ignored when it is set.
+	NoPublicFallback bool
+	// NetworkID is mixed into the salt of mutable values so records of distinct networks never share a target.
+	// Immutable values are addressed by their content and are not affected.
+	NetworkID string
+}
+
+// Salt returns the salt signed and sent to the nodes for salt.
+func (c NetworkConfig) Salt(salt string) string {
+	if c.NetworkID == "" {
+		return salt
+	}
+	return fmt.Sprintf("%d:%s%s", len(c.NetworkID), c.NetworkID, salt)
+}
+
+// Target returns the hex target of the mutable values of publicKey and salt.
+func (c NetworkConfig) Target(publicKey []byte, salt string) string {
+	return crypto.HashSha1(string(publicKey), c.Salt(salt))
+}
+
+// MutableTarget creates the mutable struct of salt in the network, see MutableTarget.
+func (c NetworkConfig) MutableTarget(privateKey ed25519.PrivateKey, val string, seq int, salt string) (*dht.MutablePut, error) {
+	return MutableTargetCas(privateKey, val, seq, seq-1, c.Salt(salt))
+}
+
+// MutableTargetCas creates the mutable struct of salt in the network, see MutableTargetCas.
+func (c NetworkConfig) MutableTargetCas(privateKey ed25519.PrivateKey, val string, seq, cas int, salt string) (*dht.MutablePut, error) {
+	return MutableTargetCas(privateKey, val, seq, cas, c.Salt(salt))
+}
+
+// usesBootstrapFile returns true if the nodes of the bootstrap file may be used.
+// The file does not record the network its nodes belong to, a configured network never reads
+// nor writes it so it never joins the public network from nodes saved by a public run.
+func (c NetworkConfig) usesBootstrapFile() bool {
+	return len(c.Bootstrap) == 0 && !c.NoPublicFallback
+}
+
+// bootstrapNodes returns the addresses to bootstrap from when the bootstrap file is not used or has none.
+func (c NetworkConfig) bootstrapNodes() ([]string, error) {
+	if len(c.Bootstrap) > 0 {
+		return c.Bootstrap, nil
+	}
+	if c.NoPublicFallback {
+		return nil, errors.New("no bootstrap node configured and falling back to the public routers is forbidden")
+	}
+	return PublicRouters, nil
+}
@@ -1,106 +1,244 @@
 package network
 
 import (
-	"fmt"
-	"log"
+	"context"
 	"net"
 	"os"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/anacrolix/torrent/util"
 	"github.com/mh-cbon/dht/bootstrap"
 	"github.com/mh-cbon/dht/dht"
 	"github.com/mh-cbon/dht/ed25519"
 	"github.com/mh-cbon/dht/security"
+	"github.com/mh-cbon/dht/stats"
 	"github.com/pkg/errors"
 )
 
-var (
-	// publicDHTNodes lists public addresses to bootstrap.
-	publicDHTNodes = []string{
-		"router.bittorrent.com:6881",
-		"router.utorrent.com:6881",
-	}
-
-	ErrValueNotFound = errors.New("value not found")
-)
-
 // DHT network helper functions.
 type DHT struct {
 	public     *dht.DHT
-	log        *log.Logger
+	log        Logger
 	storeCache map[string][]*net.UDPAddr
 	storeMx    *sync.RWMutex
+	journal    SeqJournal
+	sealer     Sealer
+	codec      Codec
+	config     NetworkConfig
+
+	updateRetries int
+	updateBackoff time.Duration
+	minReplicas   int
+	metrics       *Metrics
+	peerStats     *stats.TSPeers
+
+	bootstrapBackoff    time.Duration
+	maxBootstrapBackoff time.Duration
+}
+
+// Opt is a DHT option setter.
+type Opt func(*DHT)
+
+// Opts are DHT options.
+var Opts = struct {
+	WithSeqJournal     func(j SeqJournal) Opt
+	WithUpdateRetry    func(attempts int, backoff time.Duration) Opt
+	WithSealer         func(s Sealer) Opt
+	WithCodec          func(c Codec) Opt
+	WithNetwork        func(c NetworkConfig) Opt
+	WithBootstrapRetry func(backoff, maxBackoff time.Duration) Opt
+	WithMinReplicas    func(n int) Opt
+	WithMetrics        func(m *Metrics, peers *stats.TSPeers) Opt
+}{
+	WithSeqJournal: func(j SeqJournal) Opt {
+		return func(d *DHT) {
+			d.journal = j
+		}
+	},
+	WithUpdateRetry: func(attempts int, backoff time.Duration) Opt {
+		return func(d *DHT) {
+			d.updateRetries = attempts
+			d.updateBackoff = backoff
+		}
+	},
+	WithSealer: func(s Sealer) Opt {
+		return func(d *DHT) {
+			d.sealer = s
+		}
+	},
+	WithCodec: func(c Codec) Opt {
+		return func(d *DHT) {
+			d.codec = c
+		}
+	},
+	WithNetwork: func(c NetworkConfig) Opt {
+		return func(d *DHT) {
+			d.config = c
+		}
+	},
+	WithBootstrapRetry: func(backoff, maxBackoff time.Duration) Opt {
+		return func(d *DHT) {
+			d.bootstrapBackoff = backoff
+			d.maxBootstrapBackoff = maxBackoff
+		}
+	},
+	WithMinReplicas: func(n int) Opt {
+		return func(d *DHT) {
+			d.minReplicas = n
+		}
+	},
+	WithMetrics: func(m *Metrics, peers *stats.TSPeers) Opt {
+		return func(d *DHT) {
+			d.metrics = m
+			d.peerStats = peers
+		}
+	},
 }
 
-// NewDHT DHT instance.
-func NewDHT(public *dht.DHT, log *log.Logger) *DHT {
-	return &DHT{
+// NewDHT DHT instance, log may be nil to discard the logs.
+func NewDHT(public *dht.DHT, log Logger, opts ...Opt) *DHT {
+	if log == nil {
+		log = Discard
+	}
+	ret := &DHT{
 		public:     public,
 		log:        log,
 		storeCache: make(map[string][]*net.UDPAddr, 0),
 		storeMx:    &sync.RWMutex{},
+		codec:      JSON,
+
+		updateRetries: defaultUpdateRetries,
+		updateBackoff: defaultUpdateBackoff,
+
+		bootstrapBackoff:    defaultBootstrapBackoff,
+		maxBootstrapBackoff: defaultMaxBootstrapBackoff,
+	}
+	for _, opt := range opts {
+		opt(ret)
+	}
+	if ret.metrics != nil && ret.peerStats != nil {
+		ret.metrics.watchPeers(ret.peerStats, ret.Nodes)
 	}
+	return ret
 }
 
+// maxBootstrapRounds is the number of bootstraps allowed to agree on the public IP.
+const maxBootstrapRounds = 4
+
 // Bootstrap creates an initial DHT network table containing IP addresses and ports.
-func (d *DHT) Bootstrap(filename string) (bNodes []string, err error) {
-	d.log.Println("DHT bootstrap...")
-	var publicIP *util.CompactPeer
-	var selfID = d.public.GetID()
-	bNodes = publicDHTNodes
+// The node ID is recomputed for the public IP recommended by the nodes until they agree with it.
+// The nodes of filename are only used, and saved, for the public network,
+// see NetworkConfig.Bootstrap and NetworkConfig.NoPublicFallback.
+func (d *DHT) Bootstrap(filename string) ([]string, error) {
+	return d.BootstrapContext(context.Background(), filename)
+}
+
+// BootstrapContext is Bootstrap aborting with ctx.Err() once ctx is done.
+// When bootstrapping from the nodes of filename fails, they may all be gone,
+// it bootstraps from the configured or public routers.
+func (d *DHT) BootstrapContext(ctx context.Context, filename string) (bNodes []string, err error) {
+	d.log.Log(LevelInfo, "bootstrapping")
+	var publicIP, recommendedIP *util.CompactPeer
 
+	if !d.config.usesBootstrapFile() {
+		filename = ""
+	}
 	if filename != "" {
 		data, err := bootstrap.Get(filename)
 		if err == nil && len(data.Nodes) > 0 {
 			bNodes = data.Nodes
 			publicIP = data.OldIP
-			d.log.Println("loaded bootstrap nodes:", len(bNodes))
+			d.log.Log(LevelInfo, "loaded bootstrap nodes", "file", filename, "nodes", len(bNodes))
 		}
 	}
-
-	recommendedIP := publicIP
-	if rIP, bootErr := d.public.Bootstrap(selfID, publicIP, bNodes); bootErr != nil && len(bNodes) > 0 {
-		err = bootErr
-		return
-	} else if rIP != nil {
-		recommendedIP = rIP
-		var hostname string
-		hostname, err = os.Hostname()
-		if err != nil {
-			return
+	if len(bNodes) > 0 {
+		recommendedIP, err = d.converge(ctx, publicIP, bNodes)
+		if err != nil && ctx.Err() == nil {
+			d.log.Log(LevelWarn, "bootstrap from the file nodes failed", "file", filename, "err", err)
+			bNodes = nil
 		}
-		selfID = security.GenerateSecureNodeID(hostname, d.public.GetAddr(), &rIP.IP)
-		d.log.Printf("after bootstrap a new recommended ip was provided %v\n", rIP)
-		if rIP2, bootErr := d.public.Bootstrap(selfID, rIP, bNodes); bootErr != nil {
-			err = bootErr
-			return
-		} else if rIP2 != nil {
-			d.log.Printf("after bootstrap a new recommended ip was provided %v\n", rIP2)
-			err = fmt.Errorf("stopping now as something is going wrong")
+	}
+	if len(bNodes) == 0 {
+		if bNodes, err = d.config.bootstrapNodes(); err != nil {
 			return
 		}
+		d.log.Log(LevelInfo, "bootstrapping from routers", "routers", strings.Join(bNodes, ","))
+		recommendedIP, err = d.converge(ctx, publicIP, bNodes)
 	}
-	if len(bNodes) > 0 {
-		bNodes := d.public.BootstrapExport()
-		d.log.Println("bootstrap nodes:", len(bNodes))
-		if filename != "" {
-			_ = bootstrap.Save(filename, recommendedIP, bNodes)
-		}
+	if err != nil {
+		return
+	}
+	exported := d.public.BootstrapExport()
+	d.log.Log(LevelInfo, "bootstrapped", "nodes", len(exported))
+	if filename != "" {
+		_ = bootstrap.Save(filename, recommendedIP, exported)
 	}
-	d.log.Printf("id after bootstrap %x\n", d.public.ID())
 	if recommendedIP != nil {
-		d.log.Printf("public IP after bootstrap %v:%v\n", recommendedIP.IP, recommendedIP.Port)
+		d.log.Log(LevelInfo, "node identity", "id", []byte(d.public.ID()), "ip", recommendedIP.IP, "port", recommendedIP.Port)
+	} else {
+		d.log.Log(LevelInfo, "node identity", "id", []byte(d.public.ID()))
 	}
 	return
 }
 
+// converge bootstraps from addrs and returns the public IP the nodes agree with.
+// bep42: restart with a node ID secured for the recommended IP until the nodes agree with it.
+func (d *DHT) converge(ctx context.Context, publicIP *util.CompactPeer, addrs []string) (*util.CompactPeer, error) {
+	selfID := d.public.GetID()
+	recommendedIP := publicIP
+	for round := 0; ; round++ {
+		rIP, err := d.bootstrap(ctx, selfID, recommendedIP, addrs)
+		if err != nil {
+			return nil, err
+		} else if rIP == nil {
+			return recommendedIP, nil
+		}
+		d.log.Log(LevelInfo, "new public IP recommended", "ip", rIP.IP, "port", rIP.Port, "round", round+1)
+		if round+1 >= maxBootstrapRounds {
+			return nil, errors.Errorf("public IP did not converge after %d bootstraps", maxBootstrapRounds)
+		}
+		recommendedIP = rIP
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+		selfID = security.GenerateSecureNodeID(hostname, d.public.GetAddr(), &rIP.IP)
+	}
+}
+
+// bootstrap runs the vendored bootstrap until it returns or ctx is done.
+// The recommended IP is passed through a channel as the vendored bootstrap may still be running once ctx is done.
+func (d *DHT) bootstrap(ctx context.Context, id []byte, publicIP *util.CompactPeer, addrs []string) (*util.CompactPeer, error) {
+	recommended := make(chan *util.CompactPeer, 1)
+	err := run(ctx, func() error {
+		rIP, err := d.public.Bootstrap(id, publicIP, addrs)
+		recommended <- rIP
+		return err
+	})
+	d.metrics.bootstrap(err)
+	if err != nil {
+		return nil, err
+	}
+	return <-recommended, nil
+}
+
 // ClosesStoresForHash returns closest peers for the given hash in the DHT network.
 // These addresses can be used to store or retrieve mutable values from the DHT network.
-func (d *DHT) ClosestStoresForHash(hash string) (addr []*net.UDPAddr, err error) {
+func (d *DHT) ClosestStoresForHash(hash string) ([]*net.UDPAddr, error) {
+	return d.ClosestStoresForHashContext(context.Background(), hash)
+}
+
+// ClosestStoresForHashContext is ClosestStoresForHash aborting with ctx.Err() once ctx is done.
+func (d *DHT) ClosestStoresForHashContext(ctx context.Context, hash string) (addr []*net.UDPAddr, err error) {
+	defer func(start time.Time) {
+		d.metrics.lookup(start, len(addr), err)
+	}(time.Now())
 	//log.Println("LookupStores targetHash:", targetHash)
-	err = d.public.LookupStores(hash, nil)
+	err = run(ctx, func() error {
+		return d.public.LookupStores(hash, nil)
+	})
 	if err != nil {
 		return
 	}
@@ -119,17 +257,19 @@ func (d *DHT) ClosestStoresForHash(hash string) (addr []*net.UDPAddr, err error)
 }
 
 // closestStoresForHash cached version of ClosestStoresForHash.
-func (d *DHT) closestStoresForHash(hash string) ([]*net.UDPAddr, error) {
+func (d *DHT) closestStoresForHash(ctx context.Context, hash string) ([]*net.UDPAddr, error) {
 	d.storeMx.RLock()
 	if addr, ok := d.storeCache[hash]; ok {
 		d.storeMx.RUnlock()
+		d.metrics.cacheHit(true)
 		return addr, nil
 	}
 	d.storeMx.RUnlock()
+	d.metrics.cacheHit(false)
 
-	addr, err := d.ClosestStoresForHash(hash)
-	if err != nil {
-		return nil, err
+	addr, err := d.ClosestStoresForHashContext(ctx, hash)
+	if err != nil || len(addr) == 0 {
+		return addr, err
 	}
 	d.storeMx.Lock()
 	d.storeCache[hash] = addr
@@ -138,39 +278,66 @@ func (d *DHT) closestStoresForHash(hash string) ([]*net.UDPAddr, error) {
 	return addr, nil
 }
 
+// ForgetStores drops the cached closest stores of hash, the next query looks them up again
+// and finds the nodes which joined the network since.
+func (d *DHT) ForgetStores(hash string) {
+	d.storeMx.Lock()
+	delete(d.storeCache, hash)
+	d.storeMx.Unlock()
+}
+
 // Get mutable value from DHT network.
 // ErrValueNotFound error is returned in case of hash not found.
 func (d *DHT) Get(hash string, publicKey []byte, seq int, salt string) (string, error) {
-	addr, err := d.closestStoresForHash(hash)
+	return d.GetContext(context.Background(), hash, publicKey, seq, salt)
+}
+
+// GetContext is Get aborting the lookup and the queries with ctx.Err() once ctx is done.
+// The value with the highest seq among the valid answers is returned.
+func (d *DHT) GetContext(ctx context.Context, hash string, publicKey []byte, seq int, salt string) (string, error) {
+	r, err := d.GetLatest(ctx, hash, publicKey, seq, salt, 0)
 	if err != nil {
-		return "", errors.Wrap(err, "finding peers for get failed")
+		return "", err
 	}
-	ret, err := d.public.MGetAll(hash, publicKey, seq, salt, addr...)
-	if err != nil {
-		if strings.Contains(err.Error(), "value not found for id") {
-			return "", ErrValueNotFound
-		}
-		return "", errors.Wrap(err, "retrieving value from the DHT network failed")
-	}
-	return ret, nil
+	return r.Value, nil
 }
 
 // Put mutable value to DHT network.
 func (d *DHT) Put(val *dht.MutablePut) error {
-	addr, err := d.closestStoresForHash(val.Target)
-	if err != nil {
-		return errors.Wrap(err, "finding peers for put failed")
-	}
-	err = d.public.MPutAll(val, addr...)
-	if err != nil {
-		return errors.Wrap(err, "storing value in the DHT network failed")
-	}
-	return nil
+	return d.PutContext(context.Background(), val)
+}
+
+// PutContext is Put aborting the lookup and the queries with ctx.Err() once ctx is done.
+// Use Replicate to know which nodes stored the value.
+func (d *DHT) PutContext(ctx context.Context, val *dht.MutablePut) error {
+	_, err := d.Replicate(ctx, val)
+	return err
+}
+
+// Addr returns the local address of the node.
+func (d *DHT) Addr() *net.UDPAddr {
+	return d.public.GetAddr()
+}
+
+// ID returns the node ID.
+func (d *DHT) ID() []byte {
+	return d.public.GetID()
+}
+
+// Target returns the hex target of the mutable values of publicKey and salt in the network of the DHT.
+func (d *DHT) Target(publicKey []byte, salt string) string {
+	return d.config.Target(publicKey, salt)
 }
 
 // MutableTarget creates mutable struct for private key.
+// The CAS is set to seq-1, use MutableTargetCas to set another one.
 func MutableTarget(privateKey ed25519.PrivateKey, val string, seq int, salt string) (*dht.MutablePut, error) {
-	m, err := dht.PutFromPvk(val, salt, privateKey, seq, seq-1)
+	return MutableTargetCas(privateKey, val, seq, seq-1, salt)
+}
+
+// MutableTargetCas creates mutable struct for private key with the given CAS.
+func MutableTargetCas(privateKey ed25519.PrivateKey, val string, seq, cas int, salt string) (*dht.MutablePut, error) {
+	m, err := dht.PutFromPvk(val, salt, privateKey, seq, cas)
 	if err != nil {
 		return &dht.MutablePut{}, errors.Wrap(err, "failed to create mutable target")
 	}
@@ -0,0 +1,128 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/mh-cbon/dht/kmsg"
+	"github.com/pkg/errors"
+)
+
+// Kinds of DHT failures, an *Error wraps one of them.
+// Use errors.Is to test for a kind and errors.As to access the node details.
+var (
+	ErrValueNotFound    = errors.New("value not found")
+	ErrNoStoresFound    = errors.New("no stores found")
+	ErrTimeout          = errors.New("all queries timed out")
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrSeqTooOld        = errors.New("sequence number older than requested")
+	ErrCasMismatch      = errors.New("CAS mismatch")
+	ErrValueTooLong     = errors.New("value too long")
+	ErrSaltTooLong      = errors.New("salt too long")
+	ErrRejected         = errors.New("rejected by every node")
+	ErrUnderReplicated  = errors.New("stored by too few nodes")
+	ErrNoQuorum         = errors.New("quorum not reached")
+	ErrDecrypt          = errors.New("decryption failed")
+	ErrBrokenChunks     = errors.New("chunks missing or corrupt")
+)
+
+// NodeError is the KRPC error answered by a single node.
+type NodeError struct {
+	Addr *net.UDPAddr
+	Err  kmsg.Error
+}
+
+func (e NodeError) String() string {
+	if e.Addr == nil {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%v: %v", e.Addr, e.Err)
+}
+
+// Error is a failed DHT operation.
+type Error struct {
+	Op    string      // operation that failed
+	Kind  error       // one of the Err* kinds
+	Nodes []NodeError // errors answered by the nodes
+}
+
+func (e *Error) Error() string {
+	if len(e.Nodes) == 0 {
+		return fmt.Sprintf("%s: %v", e.Op, e.Kind)
+	}
+	nodes := make([]string, 0, len(e.Nodes))
+	for _, n := range e.Nodes {
+		nodes = append(nodes, n.String())
+	}
+	return fmt.Sprintf("%s: %v, got %d errors: [%s]", e.Op, e.Kind, len(e.Nodes), strings.Join(nodes, ", "))
+}
+
+// Unwrap returns the kind of the error.
+func (e *Error) Unwrap() error {
+	return e.Kind
+}
+
+// Cause returns the kind of the error, see errors.Cause.
+func (e *Error) Cause() error {
+	return e.Kind
+}
+
+// kindOf returns the kind of err, nil if err is not an *Error.
+func kindOf(err error) error {
+	if e, ok := err.(*Error); ok {
+		return e.Kind
+	}
+	return nil
+}
+
+// codeKinds maps KRPC error codes to error kinds, in order of precedence.
+var codeKinds = []struct {
+	code int
+	kind error
+}{
+	{kmsg.ErrorCasMismatch.Code, ErrCasMismatch},
+	{kmsg.ErrorSeqLessThanCurrent.Code, ErrSeqTooOld},
+	{kmsg.ErrorInvalidSig.Code, ErrInvalidSignature},
+	{kmsg.ErrorVTooLong.Code, ErrValueTooLong},
+	{kmsg.ErrorSaltTooLong.Code, ErrSaltTooLong},
+}
+
+// classify builds the error of op given the errors answered by the nodes out of queried nodes.
+// fallback is the kind used when no KRPC error maps to a kind.
+func classify(op string, nodes []NodeError, queried int, fallback error) *Error {
+	ret := &Error{Op: op, Kind: fallback, Nodes: nodes}
+	if queried == 0 {
+		ret.Kind = ErrNoStoresFound
+		return ret
+	}
+	var timeouts int
+	for _, n := range nodes {
+		if n.Err.Code == kmsg.ErrorTimeout.Code {
+			timeouts++
+		}
+	}
+	if timeouts == queried {
+		ret.Kind = ErrTimeout
+		return ret
+	}
+	for _, c := range codeKinds {
+		for _, n := range nodes {
+			if n.Err.Code == c.code {
+				ret.Kind = c.kind
+				return ret
+			}
+		}
+	}
+	return ret
+}
+
+// nodeErrors returns the errors answered by the nodes.
+func nodeErrors(res []response) (ret []NodeError) {
+	for _, r := range res {
+		if r.msg.E != nil {
+			ret = append(ret, NodeError{Addr: r.addr, Err: *r.msg.E})
+		}
+	}
+	return
+}
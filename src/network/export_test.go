@@ -0,0 +1,19 @@
+package network
+
+import (
+	"crypto/sha512"
+
+	"github.com/mh-cbon/dht/ed25519"
+)
+
+// EncodeManifest is encodeManifest for the tests of the network_test package.
+var EncodeManifest = encodeManifest
+
+// NewTestKey returns the private key derived from seed, hashed and clamped as the vendored ed25519 expects.
+func NewTestKey(seed string) ed25519.PrivateKey {
+	h := sha512.Sum512([]byte(seed))
+	h[0] &= 248
+	h[31] &= 127
+	h[31] |= 64
+	return ed25519.PrivateKey(h[:])
+}
@@ -0,0 +1,80 @@
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	"github.com/mh-cbon/dht/dht"
+	"github.com/pkg/errors"
+)
+
+// FileStore is a Store saved as JSON to a file after every change.
+type FileStore struct {
+	*MemoryStore
+	filename string
+}
+
+// NewFileStore creates a FileStore loading the values saved to filename, if any.
+func NewFileStore(filename string) (*FileStore, error) {
+	ret := &FileStore{MemoryStore: NewMemoryStore(), filename: filename}
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return ret, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "reading store file failed")
+	}
+	if err := json.Unmarshal(data, &ret.values); err != nil {
+		return nil, errors.Wrap(err, "decoding store file failed")
+	}
+	return ret, nil
+}
+
+// Put implements Store.
+// The values are only changed in memory once they are saved.
+func (s *FileStore) Put(ctx context.Context, val *dht.MutablePut) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, err := s.check("storing value in file failed", val)
+	if err != nil {
+		return err
+	}
+	values := s.copyValues()
+	values[val.Target] = v
+	return s.save(values)
+}
+
+// Delete implements Store.
+func (s *FileStore) Delete(ctx context.Context, hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	values := s.copyValues()
+	delete(values, hash)
+	return s.save(values)
+}
+
+func (s *FileStore) copyValues() map[string]storedValue {
+	ret := make(map[string]storedValue, len(s.values)+1)
+	for k, v := range s.values {
+		ret[k] = v
+	}
+	return ret
+}
+
+// save writes values to the file and makes them the values of the store if it succeeds.
+func (s *FileStore) save(values map[string]storedValue) error {
+	data, err := json.MarshalIndent(values, "", "   ")
+	if err != nil {
+		return errors.Wrap(err, "encoding store file failed")
+	}
+	tmp := s.filename + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return errors.Wrap(err, "writing store file failed")
+	}
+	if err := os.Rename(tmp, s.filename); err != nil {
+		return errors.Wrap(err, "writing store file failed")
+	}
+	s.values = values
+	return nil
+}
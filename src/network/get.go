@@ -0,0 +1,104 @@
+package network
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/mh-cbon/dht/kmsg"
+	"github.com/mh-cbon/dht/socket"
+	"github.com/pkg/errors"
+)
+
+// GetResult is a mutable value retrieved from the DHT network.
+type GetResult struct {
+	Value     string
+	Seq       int
+	Sign      []byte
+	PublicKey []byte
+	Salt      string
+	Target    string       // hex target
+	Agreed    int          // number of nodes which answered this value and seq
+	Responded []NodeResult // nodes which answered, with or without a value
+	Failed    []NodeResult // nodes which answered an error or timed out
+}
+
+// GetLatest retrieves the mutable value with the highest seq answered by the stores of hash.
+// Every answer is verified against publicKey and salt, invalid ones are discarded.
+// BEP44 nodes only answer a value whose seq is above seq, zero asks for any value.
+// When quorum is greater than zero, at least quorum nodes must agree on the value,
+// otherwise an ErrNoQuorum error is returned.
+// Values stored as chunks are reassembled, Sign is the signature of their manifest.
+// The network ID of the DHT is mixed into salt, see NetworkConfig.Target to compute hash.
+func (d *DHT) GetLatest(ctx context.Context, hash string, publicKey []byte, seq int, salt string, quorum int) (*GetResult, error) {
+	ret, err := d.getLatest(ctx, hash, publicKey, seq, d.config.Salt(salt), quorum)
+	if err != nil {
+		return nil, err
+	}
+	ret.Salt = salt
+	if ret.Value, err = d.unpackValue(ctx, ret.Value); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// getLatest is GetLatest without the reassembly of chunked values,
+// salt is the salt signed by the values, the network ID already mixed in.
+func (d *DHT) getLatest(ctx context.Context, hash string, publicKey []byte, seq int, salt string, quorum int) (_ *GetResult, err error) {
+	var res []response
+	defer func(start time.Time) {
+		d.metrics.get(start, res, err)
+	}(time.Now())
+	addr, err := d.closestStoresForHash(ctx, hash)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, errors.Wrap(err, "finding peers for get failed")
+	}
+	// MGet verifies the answers with rpc.CheckGetResponse.
+	res, err = batch(ctx, addr, func(remote *net.UDPAddr, onResponse func(kmsg.Msg)) (*socket.Tx, error) {
+		return d.public.MGet(remote, hash, publicKey, seq, salt, onResponse)
+	})
+	if err != nil {
+		return nil, err
+	}
+	const op = "retrieving value from the DHT network failed"
+	ret := latest(res)
+	if ret == nil {
+		return nil, classify(op, nodeErrors(res), len(addr), ErrValueNotFound)
+	}
+	if quorum > 0 && ret.Agreed < quorum {
+		return nil, &Error{Op: op, Kind: ErrNoQuorum, Nodes: nodeErrors(res)}
+	}
+	ret.PublicKey = publicKey
+	ret.Salt = salt
+	ret.Target = hash
+	ret.Responded, ret.Failed = nodeResults(res)
+	return ret, nil
+}
+
+// latest returns the value with the highest seq among valid answers,
+// values agreed by more nodes win over conflicting values of the same seq.
+func latest(res []response) *GetResult {
+	type vote struct {
+		val string
+		seq int
+	}
+	votes := map[vote]int{}
+	var ret *GetResult
+	for _, r := range res {
+		if r.msg.E != nil || r.msg.R == nil || r.msg.R.V == "" {
+			continue
+		}
+		v := vote{val: r.msg.R.V, seq: r.msg.R.Seq}
+		votes[v]++
+		if ret == nil || v.seq > ret.Seq || (v.seq == ret.Seq && votes[v] > ret.Agreed) {
+			ret = &GetResult{Value: v.val, Seq: v.seq, Sign: r.msg.R.Sign}
+		}
+		if ret.Value == v.val && ret.Seq == v.seq {
+			ret.Agreed = votes[v]
+		}
+	}
+	return ret
+}
@@ -0,0 +1,66 @@
+package network_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Ecsy/dhtstore/src/network"
+	"github.com/Ecsy/dhtstore/src/testnet"
+	"github.com/mh-cbon/dht/ed25519"
+)
+
+func TestGetLatest(t *testing.T) {
+	tn, err := testnet.New(6, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tn.Close()
+	d, err := tn.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	pvk := network.NewTestKey("latest")
+	pbk := ed25519.PublicKeyFromPvk(pvk)
+	nodes := tn.Nodes()
+	from := nodes[5].DHT()
+
+	m, err := d.PublishContext(ctx, pvk, "salt", "old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(quorum int, want string, agreed int) {
+		t.Helper()
+		r, err := d.GetLatest(ctx, m.Target, pbk, 0, "salt", quorum)
+		if err != nil {
+			t.Fatalf("quorum %d: %v", quorum, err)
+		}
+		if r.Value != want || r.Agreed != agreed {
+			t.Errorf("quorum %d: got %q agreed by %d, want %q agreed by %d", quorum, r.Value, r.Agreed, want, agreed)
+		}
+	}
+	check(6, "old", 6)
+
+	// the highest seq wins over a value agreed by more nodes.
+	mine, err := network.MutableTarget(pvk, "mine", 2, "salt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	putTo(t, from, mine, nodes[:2]...)
+	check(0, "mine", 2)
+	check(2, "mine", 2)
+	if _, err := d.GetLatest(ctx, m.Target, pbk, 0, "salt", 3); !errors.Is(err, network.ErrNoQuorum) {
+		t.Errorf("quorum 3: got error %v, want %v", err, network.ErrNoQuorum)
+	}
+
+	// among values of the same seq, the one agreed by more nodes wins.
+	theirs, err := network.MutableTarget(pvk, "theirs", 2, "salt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	putTo(t, from, theirs, nodes[2:5]...)
+	check(3, "theirs", 3)
+}
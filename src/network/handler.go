@@ -0,0 +1,129 @@
+package network
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/mh-cbon/dht/crypto"
+	"github.com/mh-cbon/dht/dht"
+	"github.com/mh-cbon/dht/kmsg"
+	"github.com/mh-cbon/dht/rpc"
+	"github.com/mh-cbon/dht/socket"
+	"github.com/mh-cbon/dht/token"
+)
+
+// storedValueTTL is how long a node keeps a value which is not put again, as the vendored nodes do.
+const storedValueTTL = 2 * time.Hour
+
+// NewQueryHandler returns the handler of the queries received by public,
+// it answers get and put queries like a BEP44 node of the public network.
+// The vendored handlers omit the seq of get answers, compare the CAS to the stored CAS
+// rather than to the stored seq, and only answer the nodes of their own lookups.
+// Other queries go to dht.StdQueryHandler.
+func NewQueryHandler(public *dht.DHT) socket.QueryHandler {
+	h := &queryHandler{
+		dht:    public,
+		std:    dht.StdQueryHandler(public),
+		mu:     &sync.Mutex{},
+		tokens: token.NewDefault(nil),
+		items:  map[string]handlerItem{},
+	}
+	return h.handle
+}
+
+type queryHandler struct {
+	dht    *dht.DHT
+	std    socket.QueryHandler
+	mu     *sync.Mutex
+	tokens *token.Server
+	items  map[string]handlerItem
+}
+
+// handlerItem is a value stored by a put query.
+type handlerItem struct {
+	kmsg.Return
+	stored time.Time
+}
+
+func (h *queryHandler) handle(msg kmsg.Msg, remote *net.UDPAddr) error {
+	switch {
+	case msg.A == nil:
+		return h.std(msg, remote)
+	case msg.Q == kmsg.QGet:
+		return h.onGet(msg, remote)
+	case msg.Q == kmsg.QPut:
+		return h.onPut(msg, remote)
+	}
+	return h.std(msg, remote)
+}
+
+func (h *queryHandler) onGet(msg kmsg.Msg, remote *net.UDPAddr) error {
+	if len(msg.A.Target) != 20 {
+		return h.dht.Error(remote, msg.T, kmsg.ErrorProtocolError)
+	}
+	hexTarget := dht.HexFromBytes([]byte(msg.A.Target))
+	ret := kmsg.Return{Token: h.tokens.CreateToken(remote)}
+	if contacts, err := h.dht.ClosestLocation(hexTarget, 8); err == nil {
+		for _, c := range contacts {
+			ret.Nodes = append(ret.Nodes, rpc.NodeInfo(c))
+		}
+	}
+	// The value is only sent when its seq is above the requested one,
+	// a zero seq is omitted from the query and asks for any value.
+	h.mu.Lock()
+	if item, ok := h.get(hexTarget); ok && (msg.A.Seq == 0 || item.Seq > msg.A.Seq) {
+		ret.V, ret.K, ret.Seq, ret.Sign = item.V, item.K, item.Seq, item.Sign
+	}
+	h.mu.Unlock()
+	return h.dht.Respond(remote, msg.T, ret)
+}
+
+func (h *queryHandler) onPut(msg kmsg.Msg, remote *net.UDPAddr) error {
+	if !h.tokens.ValidToken(msg.A.Token, remote) {
+		return h.dht.Error(remote, msg.T, kmsg.ErrorBadToken)
+	}
+	if err := rpc.CheckPutQuery(msg); err != nil {
+		return h.dht.Error(remote, msg.T, *err)
+	}
+	hexTarget := dht.ValueToHex(msg.A.V)
+	if len(msg.A.K) > 0 {
+		hexTarget = crypto.HashSha1(string(msg.A.K), msg.A.Salt)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if cur, ok := h.get(hexTarget); ok && len(msg.A.K) > 0 {
+		if msg.A.Seq < cur.Seq {
+			return h.dht.Error(remote, msg.T, kmsg.ErrorSeqLessThanCurrent)
+		}
+		if msg.A.Cas != 0 && msg.A.Cas != cur.Seq {
+			return h.dht.Error(remote, msg.T, kmsg.ErrorCasMismatch)
+		}
+	}
+	h.items[hexTarget] = handlerItem{
+		Return: kmsg.Return{V: msg.A.V, K: msg.A.K, Seq: msg.A.Seq, Sign: msg.A.Sign},
+		stored: time.Now(),
+	}
+	h.expire()
+	return h.dht.Respond(remote, msg.T, kmsg.Return{})
+}
+
+// get returns the value stored for hexTarget unless it expired, h.mu must be held.
+func (h *queryHandler) get(hexTarget string) (handlerItem, bool) {
+	item, ok := h.items[hexTarget]
+	if ok && time.Since(item.stored) > storedValueTTL {
+		delete(h.items, hexTarget)
+		return handlerItem{}, false
+	}
+	return item, ok
+}
+
+// expire drops the values which were not put again for storedValueTTL, h.mu must be held.
+func (h *queryHandler) expire() {
+	for hexTarget, item := range h.items {
+		if time.Since(item.stored) > storedValueTTL {
+			delete(h.items, hexTarget)
+		}
+	}
+}
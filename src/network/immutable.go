@@ -0,0 +1,71 @@
+package network
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/mh-cbon/dht/dht"
+	"github.com/mh-cbon/dht/kmsg"
+	"github.com/mh-cbon/dht/socket"
+	"github.com/pkg/errors"
+)
+
+// PutImmutable stores an immutable value to DHT network, it returns its hex target sha1("len(value):value").
+func (d *DHT) PutImmutable(value string) (string, error) {
+	return d.PutImmutableContext(context.Background(), value)
+}
+
+// PutImmutableContext is PutImmutable aborting with ctx.Err() once ctx is done.
+// Like Replicate, it fails when less nodes than the minimum replicas stored the value.
+func (d *DHT) PutImmutableContext(ctx context.Context, value string) (string, error) {
+	r, err := d.ReplicateImmutable(ctx, value)
+	if err != nil {
+		return "", err
+	}
+	return r.Target, nil
+}
+
+// ReplicateImmutable is PutImmutableContext reporting the answer of every node, see Replicate.
+func (d *DHT) ReplicateImmutable(ctx context.Context, value string) (*PutResult, error) {
+	return d.replicate(ctx, "storing immutable value in the DHT network failed", dht.ValueToHex(value),
+		func(remote *net.UDPAddr, onResponse func(kmsg.Msg)) (*socket.Tx, error) {
+			return d.public.Put(remote, value, onResponse)
+		})
+}
+
+// GetImmutable retrieves an immutable value from DHT network.
+// Values which do not hash to target are discarded, target is case insensitive.
+func (d *DHT) GetImmutable(target string) (string, error) {
+	return d.GetImmutableContext(context.Background(), target)
+}
+
+// GetImmutableContext is GetImmutable aborting with ctx.Err() once ctx is done.
+func (d *DHT) GetImmutableContext(ctx context.Context, target string) (_ string, err error) {
+	var res []response
+	defer func(start time.Time) {
+		d.metrics.get(start, res, err)
+	}(time.Now())
+	// compared below to the lowercase hex of the answered values.
+	target = strings.ToLower(target)
+	addr, err := d.closestStoresForHash(ctx, target)
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "", errors.Wrap(err, "finding peers for get failed")
+	}
+	res, err = batch(ctx, addr, func(remote *net.UDPAddr, onResponse func(kmsg.Msg)) (*socket.Tx, error) {
+		return d.public.Get(remote, target, onResponse)
+	})
+	if err != nil {
+		return "", err
+	}
+	for _, r := range res {
+		if r.msg.E == nil && r.msg.R != nil && r.msg.R.V != "" && dht.ValueToHex(r.msg.R.V) == target {
+			return r.msg.R.V, nil
+		}
+	}
+	return "", classify("retrieving immutable value from the DHT network failed", nodeErrors(res), len(addr), ErrValueNotFound)
+}
@@ -0,0 +1,83 @@
+package network
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// SeqJournal keeps track of the last seq published for a public key and salt.
+type SeqJournal interface {
+	// Load returns the last seq published, ok is false if nothing was published yet.
+	Load(publicKey []byte, salt string) (seq int, ok bool, err error)
+	// Store records seq as the last seq published.
+	Store(publicKey []byte, salt string, seq int) error
+}
+
+// FileJournal is a SeqJournal saved as JSON to a file.
+type FileJournal struct {
+	filename string
+	mu       *sync.Mutex
+}
+
+// NewFileJournal creates a journal saved to filename, the file is created on first Store.
+func NewFileJournal(filename string) *FileJournal {
+	return &FileJournal{
+		filename: filename,
+		mu:       &sync.Mutex{},
+	}
+}
+
+// journalKey is the key of a public key and salt in the journal file.
+func journalKey(publicKey []byte, salt string) string {
+	return hex.EncodeToString(publicKey) + ":" + salt
+}
+
+// Load implements SeqJournal.
+func (j *FileJournal) Load(publicKey []byte, salt string) (int, bool, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	entries, err := j.read()
+	if err != nil {
+		return 0, false, err
+	}
+	seq, ok := entries[journalKey(publicKey, salt)]
+	return seq, ok, nil
+}
+
+// Store implements SeqJournal.
+func (j *FileJournal) Store(publicKey []byte, salt string, seq int) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	entries, err := j.read()
+	if err != nil {
+		return err
+	}
+	entries[journalKey(publicKey, salt)] = seq
+	data, err := json.MarshalIndent(entries, "", "   ")
+	if err != nil {
+		return errors.Wrap(err, "encoding seq journal failed")
+	}
+	if err := ioutil.WriteFile(j.filename, data, 0600); err != nil {
+		return errors.Wrap(err, "writing seq journal failed")
+	}
+	return nil
+}
+
+func (j *FileJournal) read() (map[string]int, error) {
+	entries := map[string]int{}
+	data, err := ioutil.ReadFile(j.filename)
+	if os.IsNotExist(err) {
+		return entries, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "reading seq journal failed")
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, errors.Wrap(err, "decoding seq journal failed")
+	}
+	return entries, nil
+}
@@ -0,0 +1,197 @@
+package network
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mh-cbon/dht/kmsg"
+	"github.com/mh-cbon/dht/logger"
+	"github.com/pkg/errors"
+)
+
+// Level is the severity of a log entry.
+type Level int
+
+// Log levels, from the most verbose.
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel returns the level named s: debug, info, warn or error.
+func ParseLevel(s string) (Level, error) {
+	for i, n := range levelNames {
+		if strings.EqualFold(s, n) {
+			return Level(i), nil
+		}
+	}
+	return LevelInfo, errors.Errorf("unknown log level %q", s)
+}
+
+// Logger writes log entries made of a message and key/value fields,
+// keyvals alternates keys and values: "target", hash, "seq", 2.
+type Logger interface {
+	Log(level Level, msg string, keyvals ...interface{})
+}
+
+// Discard is a Logger dropping every entry.
+var Discard Logger = discard{}
+
+type discard struct{}
+
+func (discard) Log(Level, string, ...interface{}) {}
+
+// NewStdLogger returns a Logger writing logfmt lines, level=info msg="..." key=value, to l.
+// Entries below min are dropped.
+func NewStdLogger(l *log.Logger, min Level) Logger {
+	return &stdLogger{log: l, min: min}
+}
+
+type stdLogger struct {
+	log *log.Logger
+	min Level
+}
+
+func (l *stdLogger) Log(level Level, msg string, keyvals ...interface{}) {
+	if level < l.min {
+		return
+	}
+	b := &bytes.Buffer{}
+	fmt.Fprintf(b, "level=%v msg=%v", level, logfmtValue(msg))
+	keyvals = padKeyvals(keyvals)
+	for i := 0; i < len(keyvals); i += 2 {
+		fmt.Fprintf(b, " %v=%v", keyvals[i], logfmtValue(fieldValue(keyvals[i+1])))
+	}
+	l.log.Println(b.String())
+}
+
+// logfmtValue quotes s when it is empty or contains spaces, quotes or equal signs.
+func logfmtValue(s string) string {
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		return fmt.Sprintf("%q", s)
+	}
+	return s
+}
+
+// NewJSONLogger returns a Logger writing one JSON object per entry to w,
+// with the time, level and msg keys followed by the fields. Entries below min are dropped.
+func NewJSONLogger(w io.Writer, min Level) Logger {
+	return &jsonLogger{w: w, min: min, mu: &sync.Mutex{}}
+}
+
+type jsonLogger struct {
+	w   io.Writer
+	min Level
+	mu  *sync.Mutex
+}
+
+func (l *jsonLogger) Log(level Level, msg string, keyvals ...interface{}) {
+	if level < l.min {
+		return
+	}
+	b := &bytes.Buffer{}
+	fmt.Fprintf(b, `{"time":%q,"level":%q,"msg":%v`, time.Now().UTC().Format(time.RFC3339Nano), level, jsonValue(msg))
+	keyvals = padKeyvals(keyvals)
+	for i := 0; i < len(keyvals); i += 2 {
+		fmt.Fprintf(b, ",%v:%v", jsonValue(fmt.Sprint(keyvals[i])), jsonValue(keyvals[i+1]))
+	}
+	b.WriteString("}\n")
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, _ = l.w.Write(b.Bytes())
+}
+
+// jsonValue encodes v, errors and fmt.Stringers as their string.
+func jsonValue(v interface{}) string {
+	switch x := v.(type) {
+	case error, fmt.Stringer:
+		v = fieldValue(x)
+	case []byte:
+		v = hex.EncodeToString(x)
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(v))
+	}
+	return string(data)
+}
+
+// fieldValue formats the value of a field, byte slices are written in hex.
+func fieldValue(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return "null"
+	case error:
+		return x.Error()
+	case []byte:
+		return hex.EncodeToString(x)
+	}
+	return fmt.Sprint(v)
+}
+
+// padKeyvals completes a key without value.
+func padKeyvals(keyvals []interface{}) []interface{} {
+	if len(keyvals)%2 == 1 {
+		keyvals = append(keyvals, "(missing)")
+	}
+	return keyvals
+}
+
+// TrafficLogger returns a LogReceiver writing the KRPC traffic to l at debug level,
+// add it to the socket with AddLogger before it starts serving.
+func TrafficLogger(l Logger) logger.LogReceiver {
+	return traffic{log: l}
+}
+
+type traffic struct {
+	log Logger
+}
+
+func (t traffic) OnSendQuery(remote *net.UDPAddr, p map[string]interface{}) {
+	t.log.Log(LevelDebug, "send query", "remote", remote, "tx", []byte(fmt.Sprint(p["t"])), "q", p["q"])
+}
+
+func (t traffic) OnRcvQuery(remote *net.UDPAddr, p kmsg.Msg) {
+	t.log.Log(LevelDebug, "receive query", "remote", remote, "tx", []byte(p.T), "q", p.Q)
+}
+
+func (t traffic) OnSendResponse(remote *net.UDPAddr, p map[string]interface{}) {
+	keyvals := []interface{}{"remote", remote, "tx", []byte(fmt.Sprint(p["t"]))}
+	if e, ok := p["e"].(kmsg.Error); ok {
+		keyvals = append(keyvals, "code", e.Code, "error", e.Msg)
+	}
+	t.log.Log(LevelDebug, "send response", keyvals...)
+}
+
+func (t traffic) OnRcvResponse(remote *net.UDPAddr, queriedQ string, queriedA map[string]interface{}, p kmsg.Msg) {
+	keyvals := []interface{}{"remote", remote, "tx", []byte(p.T), "q", queriedQ}
+	if p.E != nil {
+		keyvals = append(keyvals, "code", p.E.Code, "error", p.E.Msg)
+	}
+	t.log.Log(LevelDebug, "receive response", keyvals...)
+}
+
+func (t traffic) OnTxNotFound(remote *net.UDPAddr, p kmsg.Msg) {
+	t.log.Log(LevelDebug, "transaction not found", "remote", remote, "tx", []byte(p.T))
+}
+
+func (t traffic) Clear() {}
@@ -0,0 +1,71 @@
+package network
+
+import (
+	"context"
+	"sync"
+
+	"github.com/mh-cbon/dht/dht"
+)
+
+// MemoryStore is a Store held in memory.
+type MemoryStore struct {
+	mu     *sync.RWMutex
+	values map[string]storedValue
+}
+
+// NewMemoryStore creates an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		mu:     &sync.RWMutex{},
+		values: map[string]storedValue{},
+	}
+}
+
+// Get implements Store.
+func (s *MemoryStore) Get(ctx context.Context, hash string, publicKey []byte, seq int, salt string) (*GetResult, error) {
+	const op = "retrieving value from memory failed"
+	s.mu.RLock()
+	v, ok := s.values[hash]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, &Error{Op: op, Kind: ErrValueNotFound}
+	}
+	return v.result(op, hash, publicKey, seq, salt)
+}
+
+// Put implements Store.
+func (s *MemoryStore) Put(ctx context.Context, val *dht.MutablePut) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.put("storing value in memory failed", val)
+}
+
+func (s *MemoryStore) put(op string, val *dht.MutablePut) error {
+	v, err := s.check(op, val)
+	if err != nil {
+		return err
+	}
+	s.values[val.Target] = v
+	return nil
+}
+
+// check returns the value to store for val if it can replace the stored one.
+func (s *MemoryStore) check(op string, val *dht.MutablePut) (storedValue, error) {
+	if err := checkPut(op, val); err != nil {
+		return storedValue{}, err
+	}
+	if cur, ok := s.values[val.Target]; ok {
+		if err := checkUpdate(op, cur, val); err != nil {
+			return storedValue{}, err
+		}
+	}
+	return storedValue{V: val.Val, K: val.Pbk, Salt: val.Salt, Sig: val.Sign, Seq: val.Seq}, nil
+}
+
+// Delete implements Store.
+func (s *MemoryStore) Delete(ctx context.Context, hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.values, hash)
+	return nil
+}
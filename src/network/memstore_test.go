@@ -0,0 +1,63 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/mh-cbon/dht/ed25519"
+)
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	pvk := NewTestKey("memory store")
+	pbk := ed25519.PublicKeyFromPvk(pvk)
+	s := NewMemoryStore()
+
+	put := func(val string, seq, cas int) error {
+		m, err := MutableTargetCas(pvk, val, seq, cas, "salt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s.Put(ctx, m)
+	}
+	if err := put("one", 1, 0); err != nil {
+		t.Fatalf("first put failed: %v", err)
+	}
+	if err := put("two", 2, 1); err != nil {
+		t.Fatalf("put with the current seq as CAS failed: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		val      string
+		seq, cas int
+		kind     error
+	}{
+		{"older seq", "zero", 1, 0, ErrSeqTooOld},
+		{"wrong CAS", "three", 3, 1, ErrCasMismatch},
+		{"value too long", string(make([]byte, MaxValueLen+1)), 3, 0, ErrValueTooLong},
+	}
+	for _, tt := range tests {
+		if err := put(tt.val, tt.seq, tt.cas); !errors.Is(err, tt.kind) {
+			t.Errorf("%s: got error %v, want %v", tt.name, err, tt.kind)
+		}
+	}
+
+	m, _ := MutableTargetCas(pvk, "forged", 3, 0, "salt")
+	m.Val = "tampered"
+	if err := s.Put(ctx, m); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("tampered value: got error %v, want %v", err, ErrInvalidSignature)
+	}
+
+	r, err := s.Get(ctx, m.Target, pbk, 0, "salt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Value != "two" || r.Seq != 2 {
+		t.Errorf("got value %q seq %d, want \"two\" seq 2", r.Value, r.Seq)
+	}
+	if _, err := s.Get(ctx, m.Target, pbk, 3, "salt"); !errors.Is(err, ErrValueNotFound) {
+		t.Errorf("get of a newer seq: got error %v, want %v", err, ErrValueNotFound)
+	}
+}
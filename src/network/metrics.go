@@ -0,0 +1,268 @@
+package network
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mh-cbon/dht/stats"
+)
+
+var (
+	// latencyBuckets are the upper bounds in seconds of the latency histograms.
+	latencyBuckets = []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+	// storesBuckets are the upper bounds of the stores found by a lookup.
+	storesBuckets = []float64{0, 1, 2, 4, 8, 16, 32, 64}
+)
+
+// Metrics are the counters and histograms of DHT operations, written in the Prometheus text format.
+// A nil *Metrics records nothing.
+type Metrics struct {
+	mu          *sync.Mutex
+	lookups     *counterVec
+	lookupTime  *histogram
+	storesFound *histogram
+	cache       *counterVec
+	gets        *counterVec
+	getTime     *histogram
+	puts        *counterVec
+	putTime     *histogram
+	publishes   *counterVec
+	nodeErrors  *counterVec
+	bootstraps  *counterVec
+	peers       func() map[string]int
+}
+
+// NewMetrics creates empty Metrics, see Opts.WithMetrics.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		mu:          &sync.Mutex{},
+		lookups:     newCounterVec("dhtstore_lookups_total", "Lookups of the closest stores of a target.", "result"),
+		lookupTime:  newHistogram("dhtstore_lookup_duration_seconds", "Duration of the lookups.", latencyBuckets),
+		storesFound: newHistogram("dhtstore_lookup_stores", "Stores found by a lookup.", storesBuckets),
+		cache:       newCounterVec("dhtstore_store_cache_total", "Closest stores read from the cache or looked up.", "result"),
+		gets:        newCounterVec("dhtstore_gets_total", "Get operations.", "result"),
+		getTime:     newHistogram("dhtstore_get_duration_seconds", "Duration of the get operations, lookup included.", latencyBuckets),
+		puts:        newCounterVec("dhtstore_puts_total", "Put operations.", "result"),
+		putTime:     newHistogram("dhtstore_put_duration_seconds", "Duration of the put operations, lookup included.", latencyBuckets),
+		publishes:   newCounterVec("dhtstore_publishes_total", "Publish and update operations.", "result"),
+		nodeErrors:  newCounterVec("dhtstore_node_errors_total", "KRPC errors answered by the nodes, by code.", "code"),
+		bootstraps:  newCounterVec("dhtstore_bootstraps_total", "Bootstrap attempts.", "result"),
+	}
+}
+
+// resultLabel is the label value of an operation which returned err.
+func resultLabel(err error) string {
+	if err == nil {
+		return "ok"
+	}
+	return "error"
+}
+
+func (m *Metrics) lookup(start time.Time, stores int, err error) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lookups.inc(resultLabel(err))
+	m.lookupTime.observe(time.Since(start).Seconds())
+	if err == nil {
+		m.storesFound.observe(float64(stores))
+	}
+}
+
+func (m *Metrics) cacheHit(hit bool) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if hit {
+		m.cache.inc("hit")
+	} else {
+		m.cache.inc("miss")
+	}
+}
+
+func (m *Metrics) get(start time.Time, res []response, err error) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.gets.inc(resultLabel(err))
+	m.getTime.observe(time.Since(start).Seconds())
+	m.countNodeErrors(res)
+}
+
+func (m *Metrics) put(start time.Time, res []response, err error) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.puts.inc(resultLabel(err))
+	m.putTime.observe(time.Since(start).Seconds())
+	m.countNodeErrors(res)
+}
+
+func (m *Metrics) publish(err error) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.publishes.inc(resultLabel(err))
+}
+
+func (m *Metrics) bootstrap(err error) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bootstraps.inc(resultLabel(err))
+}
+
+func (m *Metrics) countNodeErrors(res []response) {
+	for _, r := range res {
+		if r.msg.E != nil {
+			m.nodeErrors.inc(strconv.Itoa(r.msg.E.Code))
+		}
+	}
+}
+
+// watchPeers exports the state recorded by peers of the nodes returned by addrs.
+func (m *Metrics) watchPeers(peers *stats.TSPeers, addrs func() []*net.UDPAddr) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.peers = func() map[string]int {
+		ret := map[string]int{}
+		nodes := addrs()
+		peers.Transact(func(p *stats.Peers) {
+			for _, a := range nodes {
+				ret["known"]++
+				if p.IsActive(a) {
+					ret["active"]++
+				}
+				if p.IsTimeout(a) {
+					ret["timeout"]++
+				}
+				if p.IsRO(a) {
+					ret["readonly"]++
+				}
+				if p.IsBanned(a) {
+					ret["banned"]++
+				}
+				if !p.LastIDValid(a) {
+					ret["invalid_id"]++
+				}
+			}
+		})
+		return ret
+	}
+}
+
+// WritePrometheus writes the metrics in the Prometheus text format.
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	m.mu.Lock()
+	peers := m.peers
+	b := bufio.NewWriter(w)
+	for _, c := range []*counterVec{m.lookups, m.cache, m.gets, m.puts, m.publishes, m.nodeErrors, m.bootstraps} {
+		c.write(b)
+	}
+	for _, h := range []*histogram{m.lookupTime, m.storesFound, m.getTime, m.putTime} {
+		h.write(b)
+	}
+	m.mu.Unlock()
+
+	if peers != nil {
+		// computed out of the lock, it waits for the peer stats lock.
+		g := newCounterVec("dhtstore_peers", "Nodes of the routing table by state, as recorded by the peer stats.", "state")
+		g.kind = "gauge"
+		for state, n := range peers() {
+			g.values[state] = float64(n)
+		}
+		g.write(b)
+	}
+	return b.Flush()
+}
+
+// ServeHTTP serves the metrics in the Prometheus text format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_ = m.WritePrometheus(w)
+}
+
+// counterVec is a counter with a single label.
+type counterVec struct {
+	name, help, label, kind string
+	values                  map[string]float64
+}
+
+func newCounterVec(name, help, label string) *counterVec {
+	return &counterVec{name: name, help: help, label: label, kind: "counter", values: map[string]float64{}}
+}
+
+func (c *counterVec) inc(value string) {
+	c.values[value]++
+}
+
+func (c *counterVec) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", c.name, c.help, c.name, c.kind)
+	values := make([]string, 0, len(c.values))
+	for v := range c.values {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+	for _, v := range values {
+		fmt.Fprintf(w, "%s{%s=%q} %s\n", c.name, c.label, v, formatFloat(c.values[v]))
+	}
+}
+
+// histogram counts observations in cumulative buckets.
+type histogram struct {
+	name, help string
+	bounds     []float64
+	counts     []uint64 // per bucket, not cumulative
+	sum        float64
+	count      uint64
+}
+
+func newHistogram(name, help string, bounds []float64) *histogram {
+	return &histogram{name: name, help: help, bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+func (h *histogram) observe(v float64) {
+	h.sum += v
+	h.count++
+	for i, b := range h.bounds {
+		if v <= b {
+			h.counts[i]++
+			return
+		}
+	}
+}
+
+func (h *histogram) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	var cumulative uint64
+	for i, b := range h.bounds {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", h.name, formatFloat(b), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", h.name, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count %d\n", h.name, h.count)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,77 @@
+package network
+
+import (
+	"context"
+
+	"github.com/mh-cbon/dht/dht"
+	"github.com/mh-cbon/dht/ed25519"
+	"github.com/pkg/errors"
+)
+
+// Publish puts value under the private key and salt with the next seq.
+// The current seq is read from the DHT network, or from the seq journal when one is set,
+// the value is put with seq+1 and a CAS of the current seq.
+// Values longer than MaxValueLen are stored as immutable chunks listed by the mutable value.
+func (d *DHT) Publish(privateKey ed25519.PrivateKey, salt, value string) (*dht.MutablePut, error) {
+	return d.PublishContext(context.Background(), privateKey, salt, value)
+}
+
+// PublishContext is Publish aborting with ctx.Err() once ctx is done.
+func (d *DHT) PublishContext(ctx context.Context, privateKey ed25519.PrivateKey, salt, value string) (*dht.MutablePut, error) {
+	m, _, err := d.PublishResult(ctx, privateKey, salt, value)
+	return m, err
+}
+
+// PublishResult is PublishContext reporting the answer of every node to the put of the mutable value,
+// the result is returned along with the errors of the put as Replicate does.
+// The puts of the chunks of a long value are not reported.
+func (d *DHT) PublishResult(ctx context.Context, privateKey ed25519.PrivateKey, salt, value string) (_ *dht.MutablePut, _ *PutResult, err error) {
+	defer func() {
+		d.metrics.publish(err)
+	}()
+	publicKey := ed25519.PublicKeyFromPvk(privateKey)
+	seq, err := d.currentSeq(ctx, publicKey, salt)
+	if err != nil {
+		return nil, nil, err
+	}
+	value, err = d.packValue(ctx, value)
+	if err != nil {
+		return nil, nil, err
+	}
+	m, err := d.config.MutableTargetCas(privateKey, value, seq+1, seq, salt)
+	if err != nil {
+		return nil, nil, err
+	}
+	r, err := d.Replicate(ctx, m)
+	if err != nil {
+		return nil, r, err
+	}
+	if d.journal != nil {
+		if err := d.journal.Store(publicKey, m.Salt, m.Seq); err != nil {
+			return m, r, err
+		}
+	}
+	return m, r, nil
+}
+
+// currentSeq returns the last seq published for the public key and salt, 0 if none.
+func (d *DHT) currentSeq(ctx context.Context, publicKey []byte, salt string) (int, error) {
+	if d.journal != nil {
+		seq, ok, err := d.journal.Load(publicKey, d.config.Salt(salt))
+		if err != nil {
+			return 0, err
+		} else if ok {
+			return seq, nil
+		}
+	}
+	r, err := d.getLatest(ctx, d.Target(publicKey, salt), publicKey, 0, d.config.Salt(salt), 0)
+	if kindOf(err) == ErrValueNotFound {
+		return 0, nil
+	} else if e, ok := err.(*Error); ok {
+		// keep the kind visible to errors.Is, pkg/errors wrappers have no Unwrap.
+		return 0, &Error{Op: "reading current seq failed: " + e.Op, Kind: e.Kind, Nodes: e.Nodes}
+	} else if err != nil {
+		return 0, errors.Wrap(err, "reading current seq failed")
+	}
+	return r.Seq, nil
+}
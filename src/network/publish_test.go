@@ -0,0 +1,85 @@
+package network_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Ecsy/dhtstore/src/network"
+	"github.com/Ecsy/dhtstore/src/testnet"
+	"github.com/mh-cbon/dht/ed25519"
+)
+
+func TestPublish(t *testing.T) {
+	tn, err := testnet.New(6, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tn.Close()
+	d, err := tn.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	pvk := network.NewTestKey("publish")
+	pbk := ed25519.PublicKeyFromPvk(pvk)
+
+	// the seq follows the one found on the network, with a CAS of it.
+	for i, value := range []string{"one", "two"} {
+		m, r, err := d.PublishResult(ctx, pvk, "salt", value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Seq != i+1 || m.Cas != i {
+			t.Errorf("%s: got seq %d cas %d, want seq %d cas %d", value, m.Seq, m.Cas, i+1, i)
+		}
+		if r.Target != m.Target || r.Replicas() != 6 || len(r.Rejected)+len(r.TimedOut) > 0 {
+			t.Errorf("%s: got target %v, %d accepted, %d rejected, %d timed out, want %v stored by 6 nodes",
+				value, r.Target, r.Replicas(), len(r.Rejected), len(r.TimedOut), m.Target)
+		}
+	}
+
+	// the seq is read from the network until the journal knows the key and salt, then from the journal.
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	j := network.NewFileJournal(filepath.Join(dir, "seq.json"))
+	jd, err := tn.Client(network.Opts.WithSeqJournal(j))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := jd.PublishContext(ctx, pvk, "salt", "three")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Seq != 3 {
+		t.Errorf("with an empty journal: got seq %d, want 3", m.Seq)
+	}
+	if seq, ok, err := j.Load(pbk, "salt"); err != nil || !ok || seq != 3 {
+		t.Errorf("journal: got seq %d, %v, %v, want 3", seq, ok, err)
+	}
+	if m, err = jd.PublishContext(ctx, pvk, "salt", "four"); err != nil {
+		t.Fatal(err)
+	}
+	if m.Seq != 4 || m.Cas != 3 {
+		t.Errorf("with seq 3 in the journal: got seq %d cas %d, want seq 4 cas 3", m.Seq, m.Cas)
+	}
+
+	// a journal behind the network is trusted, the stores reject the put.
+	if err := j.Store(pbk, "salt", 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jd.PublishContext(ctx, pvk, "salt", "two again"); !errors.Is(err, network.ErrSeqTooOld) {
+		t.Errorf("with seq 1 in the journal: got error %v, want %v", err, network.ErrSeqTooOld)
+	}
+	if seq, _, err := j.Load(pbk, "salt"); err != nil || seq != 1 {
+		t.Errorf("journal after a failed put: got seq %d, %v, want 1", seq, err)
+	}
+}
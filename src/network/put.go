@@ -0,0 +1,116 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/mh-cbon/dht/dht"
+	"github.com/mh-cbon/dht/kmsg"
+	"github.com/mh-cbon/dht/socket"
+	"github.com/pkg/errors"
+)
+
+// PutResult is the outcome of a put to the closest stores of a target.
+type PutResult struct {
+	Target   string       // hex target
+	Accepted []NodeResult // nodes which stored the value
+	Rejected []NodeResult // nodes which answered a KRPC error, see NodeResult.Err
+	TimedOut []NodeResult // nodes which did not answer
+}
+
+// Replicas returns the number of nodes which stored the value.
+func (r *PutResult) Replicas() int {
+	return len(r.Accepted)
+}
+
+// conflict returns the error of the nodes which rejected the value for a CAS or seq conflict, nil if none did.
+// A single such node means another writer stored a value in between.
+func (r *PutResult) conflict(op string) *Error {
+	var nodes []NodeError
+	for _, n := range r.Rejected {
+		if n.Err.Code == kmsg.ErrorCasMismatch.Code || n.Err.Code == kmsg.ErrorSeqLessThanCurrent.Code {
+			nodes = append(nodes, NodeError{Addr: n.Addr, Err: *n.Err})
+		}
+	}
+	if len(nodes) == 0 {
+		return nil
+	}
+	return classify(op, nodes, len(nodes), ErrCasMismatch)
+}
+
+// Replicate puts a mutable value to the closest stores of its target and reports the answer of every node.
+// It fails if every node failed, or if less nodes than the minimum replicas set with
+// Opts.WithMinReplicas accepted the value. The result is returned along with such errors.
+func (d *DHT) Replicate(ctx context.Context, val *dht.MutablePut) (*PutResult, error) {
+	return d.replicate(ctx, "storing value in the DHT network failed", val.Target,
+		func(remote *net.UDPAddr, onResponse func(kmsg.Msg)) (*socket.Tx, error) {
+			return d.public.MPut(remote, val, onResponse)
+		})
+}
+
+// replicate sends the put query to the closest stores of target.
+func (d *DHT) replicate(ctx context.Context, op, target string, put queryFunc) (_ *PutResult, err error) {
+	var res []response
+	defer func(start time.Time) {
+		d.metrics.put(start, res, err)
+	}(time.Now())
+	addr, err := d.closestStoresForHash(ctx, target)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, errors.Wrap(err, "finding peers for put failed")
+	}
+	res, err = batch(ctx, addr, d.withFreshToken(target, put))
+	if err != nil {
+		return nil, err
+	}
+	ret := &PutResult{Target: target}
+	for _, r := range res {
+		n := NodeResult{Addr: r.addr, Latency: r.latency, Err: r.msg.E}
+		switch {
+		case n.Err == nil:
+			ret.Accepted = append(ret.Accepted, n)
+		case n.Err.Code == kmsg.ErrorTimeout.Code:
+			ret.TimedOut = append(ret.TimedOut, n)
+		default:
+			ret.Rejected = append(ret.Rejected, n)
+		}
+	}
+	errs := nodeErrors(res)
+	if len(errs) == len(addr) {
+		return ret, classify(op, errs, len(addr), ErrRejected)
+	}
+	if ret.Replicas() < d.minReplicas {
+		op = fmt.Sprintf("%s: %d replicas out of %d required", op, ret.Replicas(), d.minReplicas)
+		return ret, classify(op, errs, len(addr), ErrUnderReplicated)
+	}
+	return ret, nil
+}
+
+// withFreshToken sends put again with a new write token when the node rejects the cached one.
+// The vendored DHT keeps the token of every node while BEP44 nodes expire them after 10 to 15 minutes,
+// and forget them on restart, so a put long after the last get of target is rejected.
+func (d *DHT) withFreshToken(target string, put queryFunc) queryFunc {
+	return func(remote *net.UDPAddr, onResponse func(kmsg.Msg)) (*socket.Tx, error) {
+		return put(remote, func(res kmsg.Msg) {
+			if res.E == nil || res.E.Code != kmsg.ErrorBadToken.Code {
+				onResponse(res)
+				return
+			}
+			// Get stores the token answered by the node for the next put.
+			_, err := d.public.Get(remote, target, func(got kmsg.Msg) {
+				if got.E != nil || got.R == nil || got.R.Token == "" {
+					onResponse(res)
+				} else if _, err := put(remote, onResponse); err != nil {
+					onResponse(res)
+				}
+			})
+			if err != nil {
+				onResponse(res)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package network
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/mh-cbon/dht/kmsg"
+	"github.com/mh-cbon/dht/socket"
+)
+
+// queryFunc sends a single query to addr, onResponse is called with the node answer.
+type queryFunc func(addr *net.UDPAddr, onResponse func(kmsg.Msg)) (*socket.Tx, error)
+
+// response is the answer of a single node to a query.
+type response struct {
+	addr    *net.UDPAddr
+	msg     kmsg.Msg
+	latency time.Duration
+}
+
+// NodeResult is the outcome of a query to a single node.
+type NodeResult struct {
+	Addr    *net.UDPAddr
+	Latency time.Duration
+	Err     *kmsg.Error // nil if the node answered successfully
+}
+
+// nodeResults splits the answers into successful and failed ones.
+func nodeResults(res []response) (ok, failed []NodeResult) {
+	for _, r := range res {
+		n := NodeResult{Addr: r.addr, Latency: r.latency, Err: r.msg.E}
+		if n.Err == nil {
+			ok = append(ok, n)
+		} else {
+			failed = append(failed, n)
+		}
+	}
+	return
+}
+
+// batch sends a query to every address and waits for all the answers.
+// When ctx is done it cancels the pending transactions and returns the answers received so far
+// along with ctx.Err().
+// A query which could not be sent is reported as an internal issue of the node.
+func batch(ctx context.Context, addrs []*net.UDPAddr, query queryFunc) ([]response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	// buffered so late answers never block once ctx is done.
+	c := make(chan response, len(addrs))
+	txs := &pending{mu: &sync.Mutex{}}
+	for _, addr := range addrs {
+		go func(addr *net.UDPAddr) {
+			start := time.Now()
+			onResponse := func(res kmsg.Msg) {
+				c <- response{addr: addr, msg: res, latency: time.Now().Sub(start)}
+			}
+			tx, err := query(addr, onResponse)
+			if err != nil {
+				onResponse(kmsg.Msg{E: &kmsg.Error{Code: kmsg.ErrorInternalIssue.Code, Msg: err.Error()}})
+				return
+			}
+			txs.add(tx)
+		}(addr)
+	}
+
+	ret := make([]response, 0, len(addrs))
+	for range addrs {
+		select {
+		case r := <-c:
+			ret = append(ret, r)
+		case <-ctx.Done():
+			txs.cancel()
+			return ret, ctx.Err()
+		}
+	}
+	return ret, nil
+}
+
+// pending are the transactions of a batch, cancelled together once its ctx is done.
+type pending struct {
+	mu        *sync.Mutex
+	txs       []*socket.Tx
+	cancelled bool
+}
+
+// add keeps tx to cancel it later, it is cancelled at once if the batch already was.
+// Queueing sockets return no transaction.
+func (p *pending) add(tx *socket.Tx) {
+	if tx == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cancelled {
+		_ = tx.Cancel()
+		return
+	}
+	p.txs = append(p.txs, tx)
+}
+
+// cancel cancels every transaction, their late answers are dropped.
+func (p *pending) cancel() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.cancelled = true
+	for _, tx := range p.txs {
+		_ = tx.Cancel()
+	}
+	p.txs = nil
+}
+
+// run calls f in the background and waits for it to return or for ctx to be done.
+// The vendored dht calls can not be interrupted, f keeps running after ctx is done
+// and its result is dropped.
+func run(ctx context.Context, f func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- f()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
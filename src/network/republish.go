@@ -0,0 +1,176 @@
+package network
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mh-cbon/dht/dht"
+)
+
+// DefaultRepublishInterval is well below the two hours after which stores drop values.
+const DefaultRepublishInterval = 30 * time.Minute
+
+// RecordHealth is the republication state of a record.
+type RecordHealth struct {
+	Target   string // hex target
+	Seq      int
+	LastPut  time.Time // last successful put
+	NextPut  time.Time
+	LastErr  error // error of the last attempt, nil if it succeeded
+	Failures int   // consecutive failed attempts
+}
+
+// Republisher puts signed mutable values again before the stores expire them.
+type Republisher struct {
+	dht      *DHT
+	interval time.Duration
+	jitter   time.Duration
+	mu       *sync.Mutex
+	records  map[string]*republished
+	wake     chan struct{}
+}
+
+type republished struct {
+	put    *dht.MutablePut
+	health RecordHealth
+}
+
+// NewRepublisher creates a Republisher putting its records every interval plus a random delay up to jitter.
+func NewRepublisher(d *DHT, interval, jitter time.Duration) *Republisher {
+	if interval <= 0 {
+		interval = DefaultRepublishInterval
+	}
+	return &Republisher{
+		dht:      d,
+		interval: interval,
+		jitter:   jitter,
+		mu:       &sync.Mutex{},
+		records:  map[string]*republished{},
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Add tracks a signed mutable value which was just put.
+// It replaces a record of the same target unless its seq is lower.
+func (r *Republisher) Add(m *dht.MutablePut) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if old, ok := r.records[m.Target]; ok && old.put.Seq > m.Seq {
+		return
+	}
+	now := time.Now()
+	r.records[m.Target] = &republished{
+		put: m,
+		health: RecordHealth{
+			Target:  m.Target,
+			Seq:     m.Seq,
+			LastPut: now,
+			NextPut: r.next(now),
+		},
+	}
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Remove stops republishing the record of the hex target.
+func (r *Republisher) Remove(target string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.records, target)
+}
+
+// Health returns the state of every record, sorted by target.
+func (r *Republisher) Health() []RecordHealth {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ret := make([]RecordHealth, 0, len(r.records))
+	for _, rec := range r.records {
+		ret = append(ret, rec.health)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Target < ret[j].Target })
+	return ret
+}
+
+// Run republishes the records when they are due until ctx is done.
+func (r *Republisher) Run(ctx context.Context) error {
+	for {
+		select {
+		case <-time.After(r.wait()):
+		case <-r.wake:
+			continue
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		for _, rec := range r.due(time.Now()) {
+			r.republish(ctx, rec)
+		}
+	}
+}
+
+// republish puts a record, and the chunks it lists, to a fresh set of closest stores.
+func (r *Republisher) republish(ctx context.Context, rec *republished) {
+	err := r.dht.refreshChunks(ctx, rec.put.Val)
+	if err == nil {
+		r.dht.ForgetStores(rec.put.Target)
+		err = r.dht.PutContext(ctx, rec.put)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	rec.health.LastErr = err
+	if err != nil {
+		// retry sooner than the regular schedule.
+		rec.health.NextPut = now.Add(r.interval / 10)
+		rec.health.Failures++
+		r.dht.log.Log(LevelWarn, "republish failed", "target", rec.put.Target, "seq", rec.put.Seq, "failures", rec.health.Failures, "err", err)
+		return
+	}
+	rec.health.Failures = 0
+	rec.health.LastPut = now
+	rec.health.NextPut = r.next(now)
+	r.dht.log.Log(LevelDebug, "republished", "target", rec.put.Target, "seq", rec.put.Seq)
+}
+
+// next returns the time of the next put after now.
+func (r *Republisher) next(now time.Time) time.Time {
+	next := now.Add(r.interval)
+	if r.jitter > 0 {
+		next = next.Add(time.Duration(rand.Int63n(int64(r.jitter))))
+	}
+	return next
+}
+
+// wait returns the delay until the next record is due.
+func (r *Republisher) wait() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	wait := r.interval
+	now := time.Now()
+	for _, rec := range r.records {
+		if d := rec.health.NextPut.Sub(now); d < wait {
+			wait = d
+		}
+	}
+	if wait < 0 {
+		wait = 0
+	}
+	return wait
+}
+
+// due returns the records to republish at now.
+func (r *Republisher) due(now time.Time) (ret []*republished) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, rec := range r.records {
+		if !rec.health.NextPut.After(now) {
+			ret = append(ret, rec)
+		}
+	}
+	return
+}
@@ -0,0 +1,86 @@
+package network_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Ecsy/dhtstore/src/network"
+	"github.com/Ecsy/dhtstore/src/testnet"
+	"github.com/mh-cbon/dht/ed25519"
+)
+
+func TestRepublisher(t *testing.T) {
+	tn, err := testnet.New(4, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tn.Close()
+	d, err := tn.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	pvk := network.NewTestKey("republish")
+	pbk := ed25519.PublicKeyFromPvk(pvk)
+
+	m, err := d.PublishContext(ctx, pvk, "salt", "value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rp := network.NewRepublisher(d, 200*time.Millisecond, 0)
+	rp.Add(m)
+	if h := rp.Health(); len(h) != 1 || h[0].Target != m.Target || h[0].Seq != 1 {
+		t.Fatalf("got health %+v, want the record of %v seq 1", h, m.Target)
+	}
+	wait := func(what string, ok func(network.RecordHealth) bool) network.RecordHealth {
+		t.Helper()
+		for {
+			if h := rp.Health(); ok(h[0]) {
+				return h[0]
+			}
+			select {
+			case <-time.After(20 * time.Millisecond):
+			case <-ctx.Done():
+				t.Fatalf("timed out waiting for %s, health %+v", what, rp.Health())
+			}
+		}
+	}
+
+	// failures are reported while no store runs.
+	for i := 0; i < 4; i++ {
+		if err := tn.Kill(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runCtx, stop := context.WithCancel(ctx)
+	done := make(chan error, 1)
+	go func() { done <- rp.Run(runCtx) }()
+	wait("a failure", func(h network.RecordHealth) bool { return h.Failures > 0 && h.LastErr != nil })
+
+	// the restarted stores lost the value, the next attempt puts it again.
+	restarted := time.Now()
+	for i := 0; i < 4; i++ {
+		if err := tn.Restart(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wait("a republish", func(h network.RecordHealth) bool { return h.Failures == 0 && h.LastPut.After(restarted) })
+	stop()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("run returned %v, want %v", err, context.Canceled)
+	}
+
+	fresh, err := tn.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := fresh.GetLatest(ctx, m.Target, pbk, 0, "salt", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Value != "value" || r.Seq != 1 {
+		t.Errorf("got %q seq %d, want \"value\" seq 1", r.Value, r.Seq)
+	}
+}
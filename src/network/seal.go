@@ -0,0 +1,154 @@
+package network
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// Sealer encrypts values before they are signed and decrypts them once retrieved.
+// Open returns an ErrDecrypt error when the value can not be authenticated.
+type Sealer interface {
+	Seal(value string) (string, error)
+	Open(value string) (string, error)
+}
+
+const nonceLen = 24
+
+// SecretBox is a Sealer using NaCl secretbox with a key derived from a shared secret.
+type SecretBox struct {
+	key [32]byte
+}
+
+// NewSecretBox creates a SecretBox for secret, which should be random rather than a password.
+func NewSecretBox(secret []byte) (*SecretBox, error) {
+	ret := &SecretBox{}
+	kdf := hkdf.New(sha256.New, secret, nil, []byte("dhtstore secretbox"))
+	if _, err := io.ReadFull(kdf, ret.key[:]); err != nil {
+		return nil, errors.Wrap(err, "deriving secretbox key failed")
+	}
+	return ret, nil
+}
+
+// Seal implements Sealer, the sealed value is nonce+box.
+func (s *SecretBox) Seal(value string) (string, error) {
+	var nonce [nonceLen]byte
+	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return "", errors.Wrap(err, "generating nonce failed")
+	}
+	return string(secretbox.Seal(nonce[:], []byte(value), &nonce, &s.key)), nil
+}
+
+// Open implements Sealer.
+func (s *SecretBox) Open(value string) (string, error) {
+	if len(value) < nonceLen+secretbox.Overhead {
+		return "", errDecrypt("sealed value too short")
+	}
+	var nonce [nonceLen]byte
+	copy(nonce[:], value)
+	ret, ok := secretbox.Open(nil, []byte(value[nonceLen:]), &nonce, &s.key)
+	if !ok {
+		return "", errDecrypt("secretbox authentication failed")
+	}
+	return string(ret), nil
+}
+
+// Box is a Sealer using NaCl box to seal values for a set of X25519 recipients.
+// A random key seals the value with secretbox, this key is boxed for every recipient
+// from an ephemeral key pair.
+type Box struct {
+	privateKey *[32]byte
+	recipients []*[32]byte
+}
+
+// boxedKeyLen is the length of the value key boxed for a recipient.
+const boxedKeyLen = nonceLen + 32 + box.Overhead
+
+// NewBox creates a Box opening values with privateKey and sealing them for recipients.
+// privateKey may be nil for a Box which only seals.
+func NewBox(privateKey *[32]byte, recipients ...*[32]byte) *Box {
+	return &Box{
+		privateKey: privateKey,
+		recipients: recipients,
+	}
+}
+
+// Seal implements Sealer, the sealed value is
+// ephemeral public key+recipients count+boxed keys+nonce+secretbox.
+func (b *Box) Seal(value string) (string, error) {
+	if len(b.recipients) == 0 || len(b.recipients) > 255 {
+		return "", errors.Errorf("box needs 1 to 255 recipients, got %d", len(b.recipients))
+	}
+	ephemeralPub, ephemeralPvk, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", errors.Wrap(err, "generating ephemeral key failed")
+	}
+	var key [32]byte
+	if _, err := io.ReadFull(rand.Reader, key[:]); err != nil {
+		return "", errors.Wrap(err, "generating value key failed")
+	}
+
+	out := append(ephemeralPub[:], byte(len(b.recipients)))
+	for _, r := range b.recipients {
+		var nonce [nonceLen]byte
+		if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+			return "", errors.Wrap(err, "generating nonce failed")
+		}
+		out = box.Seal(append(out, nonce[:]...), key[:], &nonce, r, ephemeralPvk)
+	}
+	var nonce [nonceLen]byte
+	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return "", errors.Wrap(err, "generating nonce failed")
+	}
+	out = secretbox.Seal(append(out, nonce[:]...), []byte(value), &nonce, &key)
+	return string(out), nil
+}
+
+// Open implements Sealer.
+func (b *Box) Open(value string) (string, error) {
+	if b.privateKey == nil {
+		return "", errors.New("box has no private key to open values")
+	}
+	if len(value) < 33 {
+		return "", errDecrypt("sealed value too short")
+	}
+	var ephemeralPub [32]byte
+	copy(ephemeralPub[:], value)
+	n := int(value[32])
+	keys := []byte(value[33:])
+	if len(keys) < n*boxedKeyLen+nonceLen+secretbox.Overhead {
+		return "", errDecrypt("sealed value too short")
+	}
+	var key [32]byte
+	var found bool
+	for i := 0; i < n && !found; i++ {
+		boxed := keys[i*boxedKeyLen : (i+1)*boxedKeyLen]
+		var nonce [nonceLen]byte
+		copy(nonce[:], boxed)
+		if k, ok := box.Open(nil, boxed[nonceLen:], &nonce, &ephemeralPub, b.privateKey); ok && len(k) == 32 {
+			copy(key[:], k)
+			found = true
+		}
+	}
+	if !found {
+		return "", errDecrypt("value not sealed for this key")
+	}
+	sealed := keys[n*boxedKeyLen:]
+	var nonce [nonceLen]byte
+	copy(nonce[:], sealed)
+	ret, ok := secretbox.Open(nil, sealed[nonceLen:], &nonce, &key)
+	if !ok {
+		return "", errDecrypt("secretbox authentication failed")
+	}
+	return string(ret), nil
+}
+
+// errDecrypt is an ErrDecrypt error explained by reason.
+func errDecrypt(reason string) error {
+	return &Error{Op: "opening sealed value failed: " + reason, Kind: ErrDecrypt}
+}
@@ -0,0 +1,53 @@
+package network
+
+import (
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+func TestSecretBox(t *testing.T) {
+	s, err := NewSecretBox([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := s.Seal("value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := s.Open(sealed); err != nil || v != "value" {
+		t.Fatalf("got %q, %v, want \"value\"", v, err)
+	}
+
+	other, err := NewSecretBox([]byte("other secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Open(sealed); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("open with another secret: got error %v, want %v", err, ErrDecrypt)
+	}
+	if _, err := s.Open(sealed[:len(sealed)-1]); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("open of a truncated value: got error %v, want %v", err, ErrDecrypt)
+	}
+}
+
+func TestBox(t *testing.T) {
+	pub1, pvk1, _ := box.GenerateKey(rand.Reader)
+	pub2, pvk2, _ := box.GenerateKey(rand.Reader)
+	_, pvk3, _ := box.GenerateKey(rand.Reader)
+
+	sealed, err := NewBox(nil, pub1, pub2).Seal("value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, pvk := range []*[32]byte{pvk1, pvk2} {
+		if v, err := NewBox(pvk).Open(sealed); err != nil || v != "value" {
+			t.Errorf("recipient %d: got %q, %v, want \"value\"", i+1, v, err)
+		}
+	}
+	if _, err := NewBox(pvk3).Open(sealed); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("open by a non recipient: got error %v, want %v", err, ErrDecrypt)
+	}
+}
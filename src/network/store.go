@@ -0,0 +1,171 @@
+package network
+
+import (
+	"context"
+	"strings"
+
+	"github.com/mh-cbon/dht/crypto"
+	"github.com/mh-cbon/dht/dht"
+	"github.com/mh-cbon/dht/kmsg"
+	"github.com/mh-cbon/dht/rpc"
+	"github.com/pkg/errors"
+)
+
+// Store is a key-value store of BEP44 mutable values addressed by their hex target.
+// Every implementation verifies signatures and sizes with rpc.CheckPutQuery,
+// rejects a seq lower than the stored one and honours a non zero CAS.
+// The salt of Get is the salt signed by the values, like the salt of the values given to Put,
+// NewNetworkStore mixes the network ID into it for every implementation.
+type Store interface {
+	// Get returns the value stored for hash if its seq is at least seq.
+	Get(ctx context.Context, hash string, publicKey []byte, seq int, salt string) (*GetResult, error)
+	// Put stores a signed mutable value.
+	Put(ctx context.Context, val *dht.MutablePut) error
+	// Delete removes the value stored for hash.
+	Delete(ctx context.Context, hash string) error
+}
+
+// OpenStore returns the Store described by spec: "dht", "memory" or "file:<path>".
+// d is required by the "dht" store, when set the salts of every store are mixed with its network ID,
+// see NewNetworkStore.
+func OpenStore(spec string, d *DHT) (Store, error) {
+	var s Store
+	switch {
+	case spec == "dht":
+		if d == nil {
+			return nil, errors.New("dht store needs a DHT")
+		}
+		s = NewDHTStore(d)
+	case spec == "memory":
+		s = NewMemoryStore()
+	case strings.HasPrefix(spec, "file:"):
+		f, err := NewFileStore(strings.TrimPrefix(spec, "file:"))
+		if err != nil {
+			return nil, err
+		}
+		s = f
+	default:
+		return nil, errors.Errorf("unknown store %q", spec)
+	}
+	if d != nil {
+		s = NewNetworkStore(s, d.config)
+	}
+	return s, nil
+}
+
+// networkStore mixes the network ID into the salts given to its Store.
+type networkStore struct {
+	Store
+	config NetworkConfig
+}
+
+// NewNetworkStore returns s getting the values of the network of config:
+// the salt given to Get is mixed with the network ID as NetworkConfig.Target does for the hash.
+// The values given to Put are already signed, create them with NetworkConfig.MutableTarget.
+func NewNetworkStore(s Store, config NetworkConfig) Store {
+	return networkStore{Store: s, config: config}
+}
+
+func (s networkStore) Get(ctx context.Context, hash string, publicKey []byte, seq int, salt string) (*GetResult, error) {
+	r, err := s.Store.Get(ctx, hash, publicKey, seq, s.config.Salt(salt))
+	if err != nil {
+		return nil, err
+	}
+	r.Salt = salt
+	return r, nil
+}
+
+// checkPut ensures val would be accepted by a node, see rpc.CheckPutQuery.
+func checkPut(op string, val *dht.MutablePut) error {
+	msg := kmsg.Msg{A: &kmsg.MsgArgs{
+		V:    val.Val,
+		K:    val.Pbk,
+		Salt: val.Salt,
+		Sign: val.Sign,
+		Seq:  val.Seq,
+		Cas:  val.Cas,
+	}}
+	if err := rpc.CheckPutQuery(msg); err != nil {
+		return krpcError(op, *err)
+	}
+	if len(val.Pbk) == 0 {
+		return krpcError(op, kmsg.ErrorNoK)
+	}
+	if val.Target != crypto.HashSha1(string(val.Pbk), val.Salt) {
+		return errors.Errorf("%s: target %v does not match the public key and salt", op, val.Target)
+	}
+	return nil
+}
+
+// checkUpdate ensures val can replace the stored value cur,
+// a CAS of 0 means the value is not conditional.
+func checkUpdate(op string, cur storedValue, val *dht.MutablePut) error {
+	if val.Seq < cur.Seq {
+		return krpcError(op, kmsg.ErrorSeqLessThanCurrent)
+	}
+	if val.Cas != 0 && val.Cas != cur.Seq {
+		return krpcError(op, kmsg.ErrorCasMismatch)
+	}
+	return nil
+}
+
+// krpcError is the error of op for a KRPC error raised locally.
+func krpcError(op string, e kmsg.Error) *Error {
+	return classify(op, []NodeError{{Err: e}}, 1, ErrRejected)
+}
+
+// storedValue is a mutable value held by a local store.
+type storedValue struct {
+	V    string `json:"v"`
+	K    []byte `json:"k"`
+	Salt string `json:"salt,omitempty"`
+	Sig  []byte `json:"sig"`
+	Seq  int    `json:"seq"`
+}
+
+// result verifies a stored value for a get of publicKey, seq and salt.
+func (s storedValue) result(op, hash string, publicKey []byte, seq int, salt string) (*GetResult, error) {
+	msg := kmsg.Msg{R: &kmsg.Return{V: s.V, K: s.K, Seq: s.Seq, Sign: s.Sig}}
+	if err := rpc.CheckGetResponse(msg, publicKey, seq, salt); err != nil {
+		if err.Code == kmsg.ErrorSeqLessThanCurrent.Code {
+			// like a node, do not answer values older than requested.
+			return nil, &Error{Op: op, Kind: ErrValueNotFound}
+		}
+		return nil, krpcError(op, *err)
+	}
+	return &GetResult{
+		Value:     s.V,
+		Seq:       s.Seq,
+		Sign:      s.Sig,
+		PublicKey: publicKey,
+		Salt:      salt,
+		Target:    hash,
+		Agreed:    1,
+	}, nil
+}
+
+// dhtStore is the Store of the DHT network.
+type dhtStore struct {
+	dht *DHT
+}
+
+// NewDHTStore returns the Store of the DHT network, Delete is not supported.
+// Like the other stores, it does not mix the network ID of d into the salts, see NewNetworkStore.
+func NewDHTStore(d *DHT) Store {
+	return dhtStore{dht: d}
+}
+
+func (s dhtStore) Get(ctx context.Context, hash string, publicKey []byte, seq int, salt string) (*GetResult, error) {
+	return s.dht.getLatest(ctx, hash, publicKey, seq, salt, 0)
+}
+
+func (s dhtStore) Put(ctx context.Context, val *dht.MutablePut) error {
+	if err := checkPut("storing value in the DHT network failed", val); err != nil {
+		return err
+	}
+	return s.dht.PutContext(ctx, val)
+}
+
+func (s dhtStore) Delete(ctx context.Context, hash string) error {
+	return errors.New("values can not be deleted from the DHT network, they expire")
+}
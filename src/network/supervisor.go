@@ -0,0 +1,158 @@
+package network
+
+import (
+	"context"
+	"encoding/hex"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMinTableSize is the routing table size below which the supervisor bootstraps again.
+	DefaultMinTableSize = 8
+	// DefaultTableCheckInterval is the interval between two checks of the routing table size.
+	DefaultTableCheckInterval = time.Minute
+
+	defaultBootstrapBackoff    = time.Second
+	defaultMaxBootstrapBackoff = 5 * time.Minute
+)
+
+// BootstrapHealth is the state of a BootstrapSupervisor.
+type BootstrapHealth struct {
+	Bootstrapped  bool      // the routing table was built at least once
+	LastBootstrap time.Time // last successful bootstrap
+	Bootstraps    int       // successful bootstraps, the first one included
+	LastErr       error     // error of the last attempt, nil if it succeeded
+	Failures      int       // consecutive failed attempts
+	TableSize     int       // nodes in the routing table at the last check
+}
+
+// BootstrapSupervisor bootstraps a DHT until it succeeds and bootstraps it again
+// whenever its routing table drains.
+type BootstrapSupervisor struct {
+	dht      *DHT
+	filename string
+	minSize  int
+	interval time.Duration
+	mu       *sync.Mutex
+	health   BootstrapHealth
+}
+
+// NewBootstrapSupervisor creates a supervisor bootstrapping d with the bootstrap file filename.
+// The routing table is checked every interval and bootstrapped again when it has less than minSize nodes.
+func NewBootstrapSupervisor(d *DHT, filename string, minSize int, interval time.Duration) *BootstrapSupervisor {
+	if minSize <= 0 {
+		minSize = DefaultMinTableSize
+	}
+	if interval <= 0 {
+		interval = DefaultTableCheckInterval
+	}
+	return &BootstrapSupervisor{
+		dht:      d,
+		filename: filename,
+		minSize:  minSize,
+		interval: interval,
+		mu:       &sync.Mutex{},
+	}
+}
+
+// Health returns the state of the supervisor.
+func (s *BootstrapSupervisor) Health() BootstrapHealth {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.health
+}
+
+// Bootstrap bootstraps the DHT, failed attempts are retried with an exponential backoff until ctx is done.
+// A backoff which is not positive is the default one.
+func (s *BootstrapSupervisor) Bootstrap(ctx context.Context) error {
+	backoff, maxBackoff := s.dht.bootstrapBackoff, s.dht.maxBootstrapBackoff
+	if backoff <= 0 {
+		backoff = defaultBootstrapBackoff
+	}
+	if maxBackoff < backoff {
+		maxBackoff = defaultMaxBootstrapBackoff
+	}
+	for {
+		_, err := s.dht.BootstrapContext(ctx, s.filename)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		s.record(err)
+		if err == nil {
+			return nil
+		}
+		s.dht.log.Log(LevelWarn, "bootstrap failed", "retry_in", backoff, "err", err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// Run bootstraps the DHT, unless Bootstrap already succeeded, then watches its routing table until ctx is done.
+func (s *BootstrapSupervisor) Run(ctx context.Context) error {
+	if !s.Health().Bootstrapped {
+		if err := s.Bootstrap(ctx); err != nil {
+			return err
+		}
+	}
+	for {
+		select {
+		case <-time.After(s.interval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		size := s.dht.tableSize()
+		s.mu.Lock()
+		s.health.TableSize = size
+		s.mu.Unlock()
+		if size >= s.minSize {
+			continue
+		}
+		s.dht.log.Log(LevelWarn, "routing table drained, bootstrapping again", "nodes", size, "min", s.minSize)
+		if err := s.Bootstrap(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+// record updates the health after a bootstrap attempt.
+func (s *BootstrapSupervisor) record(err error) {
+	size := s.dht.tableSize()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.health.LastErr = err
+	s.health.TableSize = size
+	if err != nil {
+		s.health.Failures++
+		return
+	}
+	s.health.Failures = 0
+	s.health.Bootstrapped = true
+	s.health.Bootstraps++
+	s.health.LastBootstrap = time.Now()
+}
+
+// tableSize returns the number of nodes in the routing table, 0 before the first bootstrap.
+func (d *DHT) tableSize() int {
+	return len(d.Nodes())
+}
+
+// Nodes returns the addresses of the nodes in the routing table, none before the first bootstrap.
+func (d *DHT) Nodes() (ret []*net.UDPAddr) {
+	contacts, err := d.public.ClosestLocation(hex.EncodeToString(d.public.GetID()), math.MaxInt32)
+	if err != nil {
+		return nil
+	}
+	for _, c := range contacts {
+		ret = append(ret, c.GetAddr())
+	}
+	return
+}
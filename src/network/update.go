@@ -0,0 +1,103 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mh-cbon/dht/dht"
+	"github.com/mh-cbon/dht/ed25519"
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultUpdateRetries is the number of attempts of Update.
+	defaultUpdateRetries = 5
+	// defaultUpdateBackoff is the delay before the first retry of Update, it doubles on every retry.
+	defaultUpdateBackoff = 500 * time.Millisecond
+)
+
+// UpdateFunc computes the new value of a mutable item given its current value and seq.
+// old is empty and oldSeq is 0 when nothing was published yet, a value whose chunks are missing
+// fails the update with an ErrBrokenChunks error rather than being reset.
+type UpdateFunc func(old string, oldSeq int) (string, error)
+
+// Update reads the current value of the private key and salt, applies f and puts the result
+// with seq oldSeq+1 and a CAS of oldSeq.
+// When any node rejects the put for a CAS or seq conflict, the whole read-modify-write is retried
+// with an exponential backoff.
+func (d *DHT) Update(privateKey ed25519.PrivateKey, salt string, f UpdateFunc) (*dht.MutablePut, error) {
+	return d.UpdateContext(context.Background(), privateKey, salt, f)
+}
+
+// UpdateContext is Update aborting with ctx.Err() once ctx is done.
+func (d *DHT) UpdateContext(ctx context.Context, privateKey ed25519.PrivateKey, salt string, f UpdateFunc) (*dht.MutablePut, error) {
+	m, _, err := d.UpdateResult(ctx, privateKey, salt, f)
+	return m, err
+}
+
+// UpdateResult is UpdateContext reporting the answer of every node to the last put,
+// the result is returned along with the errors of the put as Replicate does.
+func (d *DHT) UpdateResult(ctx context.Context, privateKey ed25519.PrivateKey, salt string, f UpdateFunc) (_ *dht.MutablePut, res *PutResult, err error) {
+	defer func() {
+		d.metrics.publish(err)
+	}()
+	publicKey := ed25519.PublicKeyFromPvk(privateKey)
+	hash := d.Target(publicKey, salt)
+	backoff := d.updateBackoff
+	var last *Error
+	for attempt := 0; attempt < d.updateRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(backoff):
+				backoff *= 2
+			case <-ctx.Done():
+				return nil, res, ctx.Err()
+			}
+		}
+
+		var old string
+		var oldSeq int
+		r, err := d.GetLatest(ctx, hash, publicKey, 0, salt, 0)
+		if err == nil {
+			old, oldSeq = r.Value, r.Seq
+		} else if kindOf(err) != ErrValueNotFound {
+			return nil, nil, err
+		}
+
+		val, err := f(old, oldSeq)
+		if err != nil {
+			return nil, nil, err
+		}
+		if val, err = d.packValue(ctx, val); err != nil {
+			return nil, nil, err
+		}
+		m, err := d.config.MutableTargetCas(privateKey, val, oldSeq+1, oldSeq, salt)
+		if err != nil {
+			return nil, nil, err
+		}
+		if res, err = d.Replicate(ctx, m); res == nil {
+			return nil, nil, err
+		}
+		// a conflict on any node means another writer got in between, even if other nodes accepted the value.
+		last = res.conflict("storing value in the DHT network failed")
+		if last == nil {
+			if err != nil {
+				return nil, res, err
+			}
+			if d.journal != nil {
+				return m, res, d.journal.Store(publicKey, m.Salt, m.Seq)
+			}
+			return m, res, nil
+		}
+		d.log.Log(LevelInfo, "update conflict", "seq", oldSeq, "err", last.Kind, "conflicts", len(last.Nodes))
+	}
+	if last == nil {
+		return nil, nil, errors.New("updating value failed: no attempt made")
+	}
+	return nil, res, &Error{
+		Op:    fmt.Sprintf("updating value failed after %d attempts", d.updateRetries),
+		Kind:  last.Kind,
+		Nodes: last.Nodes,
+	}
+}
@@ -0,0 +1,86 @@
+package network_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Ecsy/dhtstore/src/network"
+	"github.com/Ecsy/dhtstore/src/testnet"
+	"github.com/mh-cbon/dht/dht"
+	"github.com/mh-cbon/dht/ed25519"
+	"github.com/mh-cbon/dht/kmsg"
+)
+
+// putTo puts m to the nodes directly from the node from, skipping the lookup of the closest stores.
+func putTo(t *testing.T, from *dht.DHT, m *dht.MutablePut, nodes ...*testnet.Node) {
+	t.Helper()
+	for _, n := range nodes {
+		done := make(chan *kmsg.Error, 1)
+		if _, err := from.MPut(n.Addr, m, func(res kmsg.Msg) { done <- res.E }); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case e := <-done:
+			if e != nil {
+				t.Fatalf("put to %v failed: %v", n.Addr, e)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("put to %v timed out", n.Addr)
+		}
+	}
+}
+
+func TestUpdateConflict(t *testing.T) {
+	tn, err := testnet.New(6, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tn.Close()
+	d, err := tn.Client(network.Opts.WithUpdateRetry(3, 10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	pvk := network.NewTestKey("update")
+	pbk := ed25519.PublicKeyFromPvk(pvk)
+
+	if _, err := d.PublishContext(ctx, pvk, "salt", "one"); err != nil {
+		t.Fatal(err)
+	}
+
+	// writer A stores its seq 2 on some stores after B read seq 1, B then puts its own seq 2 with a CAS of 1.
+	nodes := tn.Nodes()
+	var calls []int
+	m, err := d.UpdateContext(ctx, pvk, "salt", func(old string, oldSeq int) (string, error) {
+		calls = append(calls, oldSeq)
+		if len(calls) == 1 {
+			a, err := network.MutableTargetCas(pvk, "a", 2, 1, "salt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			putTo(t, nodes[5].DHT(), a, nodes[:2]...)
+		}
+		return old + "+b", nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 2 || calls[0] != 1 || calls[1] != 2 {
+		t.Fatalf("update called with seqs %v, want [1 2]", calls)
+	}
+	if m.Seq != 3 {
+		t.Errorf("got seq %d, want 3", m.Seq)
+	}
+	r, err := d.GetLatest(ctx, m.Target, pbk, 0, "salt", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Seq != 3 || r.Value != m.Val {
+		t.Errorf("got %q seq %d, want %q seq 3", r.Value, r.Seq, m.Val)
+	}
+	if len(r.Failed) > 0 {
+		t.Errorf("got failed nodes %v", r.Failed)
+	}
+}
@@ -0,0 +1,66 @@
+package network
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// maxWatchSlowdown caps the polling interval of Watch to this multiple of the requested interval.
+const maxWatchSlowdown = 8
+
+// Watch polls the mutable value of the public key and salt and sends it on the returned channel
+// every time a higher seq is seen, the first value found is always sent.
+// The polling interval doubles, up to maxWatchSlowdown times interval, while the value does not change
+// and goes back to interval after a change.
+// Nodes are asked for the last seq seen, BEP44 nodes then only answer a value with a higher seq.
+// The stores are looked up again when none was found or none answered, so nodes which joined since are polled.
+// The channel is closed once ctx is done. It fails if interval is not positive.
+func (d *DHT) Watch(ctx context.Context, publicKey []byte, salt string, interval time.Duration) (<-chan *GetResult, error) {
+	if interval <= 0 {
+		return nil, errors.Errorf("watch interval must be positive, got %v", interval)
+	}
+	hash := d.Target(publicKey, salt)
+	c := make(chan *GetResult)
+	go func() {
+		defer close(c)
+		var seq int
+		found := false
+		wait := interval
+		for {
+			r, err := d.GetLatest(ctx, hash, publicKey, seq, salt, 0)
+			if ctx.Err() != nil {
+				return
+			}
+			if err == nil && (!found || r.Seq > seq) {
+				found = true
+				seq = r.Seq
+				wait = interval
+				select {
+				case c <- r:
+				case <-ctx.Done():
+					return
+				}
+			} else {
+				kind := kindOf(err)
+				if err != nil && kind != ErrValueNotFound {
+					d.log.Log(LevelWarn, "watch get failed", "target", hash, "err", err)
+				}
+				if kind == ErrNoStoresFound || kind == ErrTimeout {
+					d.ForgetStores(hash)
+				}
+				if wait *= 2; wait > maxWatchSlowdown*interval {
+					wait = maxWatchSlowdown * interval
+				}
+			}
+
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return c, nil
+}
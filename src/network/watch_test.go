@@ -0,0 +1,62 @@
+package network_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Ecsy/dhtstore/src/network"
+	"github.com/Ecsy/dhtstore/src/testnet"
+	"github.com/mh-cbon/dht/ed25519"
+)
+
+func TestWatch(t *testing.T) {
+	tn, err := testnet.New(6, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tn.Close()
+	d, err := tn.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	pvk := network.NewTestKey("watch")
+	pbk := ed25519.PublicKeyFromPvk(pvk)
+
+	if _, err := d.Watch(ctx, pbk, "salt", 0); err == nil {
+		t.Error("watch with a zero interval did not fail")
+	}
+
+	if _, err := d.PublishContext(ctx, pvk, "salt", "one"); err != nil {
+		t.Fatal(err)
+	}
+	c, err := d.Watch(ctx, pbk, "salt", 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := func(want string, seq int) {
+		t.Helper()
+		select {
+		case r, ok := <-c:
+			if !ok {
+				t.Fatalf("channel closed waiting for %q", want)
+			}
+			if r.Value != want || r.Seq != seq {
+				t.Fatalf("got %q seq %d, want %q seq %d", r.Value, r.Seq, want, seq)
+			}
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for %q", want)
+		}
+	}
+	next("one", 1)
+	if _, err := d.PublishContext(ctx, pvk, "salt", "two"); err != nil {
+		t.Fatal(err)
+	}
+	next("two", 2)
+
+	cancel()
+	for range c {
+	}
+}
@@ -0,0 +1,268 @@
+// Package testnet runs a private DHT network of nodes listening on the loopback interface.
+// It lets network.DHT be exercised offline, nodes can be killed and restarted during a test.
+//
+// The vendored bootstrap only learns the nodes listed in find_node answers,
+// so the network runs a router, like router.bittorrent.com on the public network,
+// answering find_node with every running node. The nodes answer queries with network.NewQueryHandler
+// as the dhtstore nodes do.
+package testnet
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Ecsy/dhtstore/src/network"
+	"github.com/mh-cbon/dht/dht"
+	"github.com/mh-cbon/dht/kmsg"
+	"github.com/mh-cbon/dht/socket"
+	"github.com/pkg/errors"
+)
+
+// QueryTimeout is the query timeout of the nodes, kept short as loopback nodes answer immediately.
+var QueryTimeout = 500 * time.Millisecond
+
+// Node is a node of the test network.
+type Node struct {
+	Addr *net.UDPAddr // kept across restarts
+	id   []byte
+	dht  *dht.DHT // nil while the node is stopped
+}
+
+// DHT returns the running node, nil if it is stopped.
+func (n *Node) DHT() *dht.DHT {
+	return n.dht
+}
+
+// Network is a set of nodes bootstrapped to each other.
+type Network struct {
+	mu      *sync.Mutex
+	nodes   []*Node
+	clients []*dht.DHT
+	router  *Node
+	log     network.Logger
+
+	// routes are the running nodes answered by the router,
+	// guarded by their own lock as the router answers while mu is held by a bootstrap.
+	routesMu *sync.RWMutex
+	routes   kmsg.CompactIPv4NodeInfo
+}
+
+// New starts n nodes on 127.0.0.1 and bootstraps them to each other.
+// log may be nil to discard the logs.
+func New(n int, log network.Logger) (*Network, error) {
+	ret := &Network{
+		mu:       &sync.Mutex{},
+		log:      log,
+		routesMu: &sync.RWMutex{},
+	}
+	ret.router = &Node{id: randomID()}
+	if err := ret.start(ret.router, "127.0.0.1:0", ret.routerHandler); err != nil {
+		return nil, err
+	}
+	for i := 0; i < n; i++ {
+		node := &Node{id: randomID()}
+		if err := ret.startNode(node, "127.0.0.1:0"); err != nil {
+			ret.Close()
+			return nil, err
+		}
+		ret.nodes = append(ret.nodes, node)
+	}
+	ret.updateRoutes()
+	for i := range ret.nodes {
+		if err := ret.bootstrap(ret.nodes[i].dht, ret.nodes[i].id); err != nil {
+			ret.Close()
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+// Nodes returns the nodes of the network.
+func (t *Network) Nodes() []*Node {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]*Node{}, t.nodes...)
+}
+
+// Addrs returns the address of the router, suitable to bootstrap another node.
+func (t *Network) Addrs() []string {
+	return []string{t.router.Addr.String()}
+}
+
+// Config returns a network.NetworkConfig bootstrapping from the router, never from the public routers.
+func (t *Network) Config() network.NetworkConfig {
+	return network.NetworkConfig{
+		Bootstrap:        t.Addrs(),
+		NoPublicFallback: true,
+	}
+}
+
+// Kill stops the node i, its stored values are lost.
+func (t *Network) Kill(i int) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	node, err := t.node(i)
+	if err != nil {
+		return err
+	}
+	if node.dht == nil {
+		return fmt.Errorf("node %d is not running", i)
+	}
+	err = node.dht.Close()
+	node.dht = nil
+	t.updateRoutes()
+	return err
+}
+
+// Restart starts again the killed node i on its previous address and bootstraps it.
+func (t *Network) Restart(i int) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	node, err := t.node(i)
+	if err != nil {
+		return err
+	}
+	if node.dht != nil {
+		return fmt.Errorf("node %d is running", i)
+	}
+	if err := t.startNode(node, node.Addr.String()); err != nil {
+		return err
+	}
+	t.updateRoutes()
+	return t.bootstrap(node.dht, node.id)
+}
+
+// Client starts a new node bootstrapped to the network and returns it as a network.DHT.
+// Its table is already built, network.DHT.Bootstrap must not be called.
+func (t *Network) Client(opts ...network.Opt) (*network.DHT, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	node := &Node{id: randomID()}
+	if err := t.startNode(node, "127.0.0.1:0"); err != nil {
+		return nil, err
+	}
+	t.clients = append(t.clients, node.dht)
+	if err := t.bootstrap(node.dht, node.id); err != nil {
+		return nil, err
+	}
+	return network.NewDHT(node.dht, t.log, opts...), nil
+}
+
+// Close stops every node and client.
+func (t *Network) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var ret error
+	for _, n := range t.nodes {
+		if n.dht != nil {
+			if err := n.dht.Close(); err != nil && ret == nil {
+				ret = err
+			}
+			n.dht = nil
+		}
+	}
+	for _, c := range t.clients {
+		if err := c.Close(); err != nil && ret == nil {
+			ret = err
+		}
+	}
+	t.clients = nil
+	if t.router.dht != nil {
+		if err := t.router.dht.Close(); err != nil && ret == nil {
+			ret = err
+		}
+		t.router.dht = nil
+	}
+	return ret
+}
+
+func (t *Network) node(i int) (*Node, error) {
+	if i < 0 || i >= len(t.nodes) {
+		return nil, fmt.Errorf("no node %d in a network of %d nodes", i, len(t.nodes))
+	}
+	return t.nodes[i], nil
+}
+
+// updateRoutes refreshes the nodes answered by the router.
+func (t *Network) updateRoutes() {
+	var routes kmsg.CompactIPv4NodeInfo
+	for _, n := range t.nodes {
+		if n.dht != nil {
+			info := kmsg.NodeInfo{Addr: n.Addr}
+			copy(info.ID[:], n.id)
+			routes = append(routes, info)
+		}
+	}
+	t.routesMu.Lock()
+	t.routes = routes
+	t.routesMu.Unlock()
+}
+
+// routerHandler answers find_node queries with every running node.
+func (t *Network) routerHandler(d *dht.DHT) socket.QueryHandler {
+	std := dht.StdQueryHandler(d)
+	return func(msg kmsg.Msg, remote *net.UDPAddr) error {
+		if msg.Q != kmsg.QFindNode {
+			return std(msg, remote)
+		}
+		t.routesMu.RLock()
+		routes := t.routes
+		t.routesMu.RUnlock()
+		return d.Respond(remote, msg.T, kmsg.Return{Nodes: routes})
+	}
+}
+
+// startNode listens a new DHT node storing values on addr, previous values are lost.
+func (t *Network) startNode(node *Node, addr string) error {
+	return t.start(node, addr, network.NewQueryHandler)
+}
+
+// start listens a new DHT node on addr.
+func (t *Network) start(node *Node, addr string, handler func(*dht.DHT) socket.QueryHandler) error {
+	udpAddr, err := net.ResolveUDPAddr("udp4", addr)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp4", udpAddr)
+	if err != nil {
+		return errors.Wrap(err, "listening test node failed")
+	}
+	public := dht.New(
+		dht.Opts.WithSocket(conn),
+		dht.Opts.ID(string(node.id)),
+		dht.Opts.WithTimeout(QueryTimeout),
+		dht.Opts.WithK(20),
+		dht.Opts.WithConcurrency(8),
+	)
+	listenErr := make(chan error, 1)
+	go func() {
+		listenErr <- public.Listen(handler(public))
+	}()
+	select {
+	case err := <-listenErr:
+		return errors.Wrap(err, "listening test node failed")
+	case <-time.After(10 * time.Millisecond):
+	}
+	node.Addr = conn.LocalAddr().(*net.UDPAddr)
+	node.dht = public
+	return nil
+}
+
+// bootstrap builds the table of public from the router.
+func (t *Network) bootstrap(public *dht.DHT, id []byte) error {
+	if _, err := public.Bootstrap(id, nil, t.Addrs()); err != nil {
+		return errors.Wrap(err, "bootstrapping test node failed")
+	}
+	return nil
+}
+
+func randomID() []byte {
+	id := make([]byte, 20)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+	return id
+}
@@ -0,0 +1,120 @@
+package testnet
+
+import (
+	"context"
+	"crypto/sha512"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Ecsy/dhtstore/src/network"
+	"github.com/mh-cbon/dht/ed25519"
+)
+
+func TestKillRestart(t *testing.T) {
+	tn, err := New(6, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tn.Close()
+	d, err := tn.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	target, err := d.PutImmutableContext(ctx, "value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(d *network.DHT) (string, error) {
+		return d.GetImmutableContext(ctx, target)
+	}
+	if v, err := get(d); err != nil || v != "value" {
+		t.Fatalf("got %q, %v, want \"value\"", v, err)
+	}
+
+	// the value survives as long as one store is running.
+	for i := 0; i < 3; i++ {
+		if err := tn.Kill(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tn.Kill(0); err == nil {
+		t.Error("killing a stopped node did not fail")
+	}
+	if v, err := get(d); err != nil || v != "value" {
+		t.Fatalf("with 3 nodes killed: got %q, %v, want \"value\"", v, err)
+	}
+
+	// restarted nodes lost their values, the value is gone once the others are killed too.
+	for i := 0; i < 3; i++ {
+		if err := tn.Restart(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 3; i < 6; i++ {
+		if err := tn.Kill(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fresh, err := tn.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := get(fresh); !errors.Is(err, network.ErrValueNotFound) {
+		t.Fatalf("with the stores restarted: got error %v, want %v", err, network.ErrValueNotFound)
+	}
+
+	// the restarted nodes store values again.
+	if _, err := fresh.PutImmutableContext(ctx, "value"); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := get(fresh); err != nil || v != "value" {
+		t.Fatalf("after a new put: got %q, %v, want \"value\"", v, err)
+	}
+	if len(tn.Nodes()) != 6 {
+		t.Errorf("got %d nodes, want 6", len(tn.Nodes()))
+	}
+}
+
+func TestGetSeq(t *testing.T) {
+	tn, err := New(4, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tn.Close()
+	d, err := tn.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	h := sha512.Sum512([]byte("seq"))
+	h[0] &= 248
+	h[31] &= 127
+	h[31] |= 64
+	pvk := ed25519.PrivateKey(h[:])
+	pbk := ed25519.PublicKeyFromPvk(pvk)
+	m, err := tn.Config().MutableTarget(pvk, "value", 2, "salt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.PutContext(ctx, m); err != nil {
+		t.Fatal(err)
+	}
+
+	// like BEP44 nodes, the value is only answered when its seq is above the requested one.
+	for _, seq := range []int{0, 1} {
+		if r, err := d.GetLatest(ctx, m.Target, pbk, seq, "salt", 0); err != nil || r.Value != "value" {
+			t.Errorf("seq %d: got %v, %v, want \"value\"", seq, r, err)
+		}
+	}
+	for _, seq := range []int{2, 3} {
+		if _, err := d.GetLatest(ctx, m.Target, pbk, seq, "salt", 0); !errors.Is(err, network.ErrValueNotFound) {
+			t.Errorf("seq %d: got error %v, want %v", seq, err, network.ErrValueNotFound)
+		}
+	}
+}